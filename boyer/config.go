@@ -0,0 +1,211 @@
+package boyer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quux00/fslocate/common"
+)
+
+//
+// root is a top level dir to index, with its options from the config
+//
+type root struct {
+	common.RootConfig
+	global *common.IgnorePatterns // the rules for all roots
+	own    *common.IgnorePatterns // the rules for this root only
+	inner  map[string]bool        // the paths of the other roots under this one
+	dev    uint64                 // device of the root dir, for OneFilesystem
+	hasDev bool
+}
+
+func newRoot(log logger, cfg common.RootConfig, global, own *common.IgnorePatterns) *root {
+	r := &root{RootConfig: cfg, global: global, own: own}
+	if cfg.OneFilesystem {
+		if info, err := os.Stat(cfg.Path); err == nil {
+			r.dev, r.hasDev = common.DeviceOf(info)
+		}
+	}
+	if cfg.FollowSymlinks {
+		if info, err := os.Stat(cfg.Path); err == nil {
+			if _, _, ok := common.FileId(info); !ok {
+				log.warnf("symlink cycles can't be spotted on this platform:"+
+					" symlinks under %s will not be followed\n", cfg.Path)
+			}
+		}
+	}
+	return r
+}
+
+//
+// match returns the ignore rule that decides if path, under r, is
+// indexed, or nil if none match. The root's own rules come before the
+// global ones, so a ! rule in them can re-include what a global rule
+// ignores.
+//
+func (r *root) match(path string, isDir bool) *common.IgnoreRule {
+	if rule := r.own.Match(path, isDir); rule != nil {
+		return rule
+	}
+	return r.global.Match(path, isDir)
+}
+
+// ignored says if path, under r, should not be indexed
+func (r *root) ignored(path string, isDir bool) bool {
+	rule := r.match(path, isDir)
+	return rule != nil && !rule.Negate
+}
+
+//
+// isInner says if path is another root under r. Everything under a
+// nested root belongs to it, not r, so r's walk doesn't go into it:
+// it is walked from its own root with its own options.
+//
+func (r *root) isInner(path string) bool {
+	return r.inner[path]
+}
+
+// atMaxDepth says if the contents of a dir depth levels below r are too deep to index
+func (r *root) atMaxDepth(depth int) bool {
+	return r.MaxDepth > 0 && depth >= r.MaxDepth
+}
+
+//
+// onOtherFs says if the dir with the given info is on a different
+// filesystem from r and r is limited to one filesystem
+//
+func (r *root) onOtherFs(info os.FileInfo) bool {
+	if !r.hasDev {
+		return false
+	}
+	dev, ok := common.DeviceOf(info)
+	return ok && dev != r.dev
+}
+
+//
+// loadRoots reads in the roots to index and their options from the
+// config file, or if there isn't one, from the index file and ignore
+// file. Also returns the file the ignore rules came from, which is
+// what the db header records the hash of.
+//
+func (fl BoyerFsLocate) loadRoots() ([]*root, string, error) {
+	log := fl.log()
+	var roots []*root
+	if fl.ConfigFile != "" && common.FileExists(fl.ConfigFile) {
+		cfg, err := common.ReadConfig(fl.ConfigFile)
+		if err != nil {
+			return nil, "", err
+		}
+		log.prn("Read config from " + fl.ConfigFile)
+		global, err := common.NewIgnorePatterns("", fl.ConfigFile, cfg.Ignore)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, rc := range cfg.Roots {
+			rc.DirIgnore = rc.DirIgnore || fl.DirIgnore
+			own, err := common.NewIgnorePatterns(rc.Path, fl.ConfigFile, rc.Ignore)
+			if err != nil {
+				return nil, "", err
+			}
+			roots = append(roots, newRoot(log, rc, global, own))
+		}
+		nestRoots(roots)
+		return roots, fl.ConfigFile, nil
+	}
+
+	paths, err := getTopLevelEntries(fl.IndexFile, make([]string, 0, 16))
+	if err != nil {
+		return nil, "", err
+	}
+	global := common.ReadIgnoreFile(fl.IgnoreFile, fl.Warnings)
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if seen[path] {
+			log.warnf("%s is listed twice in %s\n", path, fl.IndexFile)
+			continue
+		}
+		seen[path] = true
+		roots = append(roots, newRoot(log, common.RootConfig{Path: path, DirIgnore: fl.DirIgnore}, global, nil))
+	}
+	nestRoots(roots)
+	return roots, fl.IgnoreFile, nil
+}
+
+//
+// nestRoots fills in the roots nested under each of roots, so that the
+// entries under a nested root are only indexed once, by the innermost
+// root they are under
+//
+func nestRoots(roots []*root) {
+	for _, r := range roots {
+		for _, o := range roots {
+			if o != r && underDir(o.Path, r.Path) {
+				if r.inner == nil {
+					r.inner = make(map[string]bool)
+				}
+				r.inner[o.Path] = true
+			}
+		}
+	}
+}
+
+// underDir says if path is below dir
+func underDir(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, PATH_SEP)+PATH_SEP)
+}
+
+func rootPaths(roots []*root) []string {
+	paths := make([]string, len(roots))
+	for i, r := range roots {
+		paths[i] = r.Path
+	}
+	return paths
+}
+
+//
+// startDirs returns the queuedDirs to start walking from for each root
+//
+func startDirs(roots []*root) []queuedDir {
+	dirs := make([]queuedDir, len(roots))
+	for i, r := range roots {
+		dirs[i] = queuedDir{path: r.Path, root: r}
+	}
+	return dirs
+}
+
+// rootsUnder returns the roots below dir
+func rootsUnder(roots []*root, dir string) []*root {
+	var under []*root
+	for _, r := range roots {
+		if underDir(r.Path, dir) {
+			under = append(under, r)
+		}
+	}
+	return under
+}
+
+//
+// rootOf returns the root path is under (the innermost one, if roots
+// are nested) and how many levels below it path is. Returns nil if
+// path isn't under any of the roots.
+//
+func rootOf(roots []*root, path string) (*root, int) {
+	var best *root
+	for _, r := range roots {
+		if path == r.Path || underDir(path, r.Path) {
+			if best == nil || len(r.Path) > len(best.Path) {
+				best = r
+			}
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	rel := strings.Trim(strings.TrimPrefix(path, best.Path), PATH_SEP)
+	if rel == "" {
+		return best, 0
+	}
+	return best, strings.Count(rel, PATH_SEP) + 1
+}
@@ -0,0 +1,122 @@
+package boyer
+
+import (
+	"os"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// DIR_IGNORE_FILES are the ignore files read in each dir when per-dir
+// ignore files are on. Their rules apply to everything under the dir
+// they are in, with anchored rules relative to that dir. The rules in
+// .fslocateignore take precedence over those in .gitignore.
+//
+var DIR_IGNORE_FILES = []string{".gitignore", ".fslocateignore"}
+
+//
+// ignoreStack is the rules from the per-dir ignore files of a dir and
+// the dirs above it, innermost first, so the rules of a dir take
+// precedence over those of the dirs above it
+//
+type ignoreStack struct {
+	rules  *common.IgnorePatterns // nil for an ignore file that was removed
+	stale  bool                   // the file changed or was removed since the previous db, so its entries can't be reused
+	parent *ignoreStack
+}
+
+// match returns the innermost rule that matches path, or nil if none do
+func (s *ignoreStack) match(path string, isDir bool) *common.IgnoreRule {
+	for ; s != nil; s = s.parent {
+		if rule := s.rules.Match(path, isDir); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+//
+// isStale says if any of the ignore files in s changed since the
+// previous db, meaning entries they ignored then may not be ignored now
+//
+func (s *ignoreStack) isStale() bool {
+	for ; s != nil; s = s.parent {
+		if s.stale {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// readDirIgnores returns d's ignore stack with the rules from the
+// ignore files in d added, if its root has per-dir ignore files on.
+// prev is what the previous db has for d, if anything: an ignore
+// file with a different mtime from the one recorded there is stale,
+// and so is one recorded there that is gone now, as what it ignored
+// may not be ignored any more.
+//
+func readDirIgnores(log logger, d queuedDir, prev *prevDir) *ignoreStack {
+	s := d.ignores
+	if !d.root.DirIgnore {
+		return s
+	}
+	for _, name := range DIR_IGNORE_FILES {
+		fpath := common.CreateFullPath(d.path, name)
+		info, err := os.Stat(fpath)
+		if err != nil {
+			if prev.hasFile(fpath) {
+				log.prf("Ignore file removed: %s\n", fpath)
+				s = &ignoreStack{stale: true, parent: s}
+			}
+			continue
+		}
+		rules, err := common.ParseIgnoreFile(fpath, d.path, log.warn)
+		if err != nil {
+			log.warnf("Unable to read ignore file: %v\n", err)
+			continue
+		}
+		log.prf("Read ignore file: %s\n", fpath)
+		s = &ignoreStack{
+			rules:  rules,
+			stale:  !prev.hasFileAt(fpath, info.ModTime().UnixNano()),
+			parent: s,
+		}
+	}
+	return s
+}
+
+func isDirIgnoreFile(name string) bool {
+	for _, n := range DIR_IGNORE_FILES {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFile says if the previous db has the file at fpath
+func (prev *prevDir) hasFile(fpath string) bool {
+	_, ok := prev.file(fpath)
+	return ok
+}
+
+// hasFileAt says if the previous db has the file at fpath with the given mtime
+func (prev *prevDir) hasFileAt(fpath string, mtime int64) bool {
+	e, ok := prev.file(fpath)
+	return ok && e.Mtime == mtime
+}
+
+// file returns what the previous db has for the file at fpath, if anything
+func (prev *prevDir) file(fpath string) (fsentry.E, bool) {
+	if prev == nil {
+		return fsentry.E{}, false
+	}
+	for _, e := range prev.files {
+		if e.Path == fpath {
+			return e, true
+		}
+	}
+	return fsentry.E{}, false
+}
@@ -0,0 +1,135 @@
+package boyer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// Explanation says why a path is or isn't indexed, as worked out by
+// Explain
+//
+type Explanation struct {
+	Path     string             // the absolute path explained
+	Exists   bool               // if the path is on disk
+	Root     string             // the root the path is under, or "" if none
+	Depth    int                // how many levels below the root the path is
+	Rule     *common.IgnoreRule // the rule deciding if the path (or a dir above it) is ignored, or nil if none
+	RulePath string             // the path Rule matched: Path or a dir above it
+	Excluded string             // why the path isn't indexed, if not for a rule: max depth, a symlink or another filesystem
+	InDb     bool               // if the path is in the current db
+	DbErr    error              // why the db couldn't be searched, if it couldn't
+}
+
+//
+// Indexed says if the path would be indexed: it is under a root and
+// isn't excluded or ignored by a rule (or a dir above it isn't)
+//
+func (ex *Explanation) Indexed() bool {
+	return ex.Root != "" && ex.Excluded == "" && (ex.Rule == nil || ex.Rule.Negate)
+}
+
+//
+// Explain works out if path would be indexed, the same way the indexer
+// does: it finds the root path is under, then goes down from the root
+// to path, checking each dir on the way for the ignore rules, max depth,
+// symlinks and filesystem boundaries that would stop the indexer from
+// getting to path. Also looks path up in the current db.
+//
+func (fl BoyerFsLocate) Explain(ctx context.Context, path string) (*Explanation, error) {
+	fl = fl.withDefaults()
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	roots, _, err := fl.loadRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	ex := &Explanation{Path: abspath}
+	_, err = os.Lstat(abspath)
+	ex.Exists = err == nil
+	if r, depth := rootOf(roots, abspath); r != nil {
+		ex.Root, ex.Depth = r.Path, depth
+		explainUnder(fl.log(), r, ex)
+	}
+
+	_, ex.DbErr = fl.Find(ctx, common.Query{Terms: []string{abspath}}, func(e fsentry.E) {
+		if e.Path == abspath {
+			ex.InDb = true
+		}
+	})
+	return ex, nil
+}
+
+// explainUnder fills in ex going down from root r to ex.Path
+func explainUnder(log logger, r *root, ex *Explanation) {
+	rel := strings.Trim(strings.TrimPrefix(ex.Path, r.Path), PATH_SEP)
+	if rel == "" {
+		return
+	}
+	elems := strings.Split(rel, PATH_SEP)
+	d := queuedDir{path: r.Path, root: r}
+	if info, err := os.Stat(r.Path); err == nil {
+		d = d.enter(info)
+	}
+	for i, el := range elems {
+		last := i == len(elems)-1
+		if r.atMaxDepth(d.depth) {
+			ex.Excluded = fmt.Sprintf("%s is at max_depth %d below its root", d.path, r.MaxDepth)
+			return
+		}
+		d.ignores = readDirIgnores(log, d, nil)
+
+		fpath := common.CreateFullPath(d.path, el)
+		info, err := os.Lstat(fpath)
+		isLink := err == nil && info.Mode()&os.ModeSymlink != 0
+		follow := isLink && d.followLink(log, fpath)
+		// a dir on the way to the path has to be a dir for the path to exist
+		isDir := !last || (err == nil && info.IsDir()) || follow
+		rule := d.ignores.match(fpath, isDir)
+		if rule == nil {
+			rule = r.match(fpath, isDir)
+		}
+		if rule != nil && (!rule.Negate || last) {
+			ex.Rule, ex.RulePath = rule, fpath
+			if !rule.Negate {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+		if isLink && !follow && !last {
+			if r.FollowSymlinks {
+				ex.Excluded = fmt.Sprintf("%s is a symlink that isn't followed: it would make a cycle,"+
+					" or doesn't point to a dir on the same filesystem", fpath)
+			} else {
+				ex.Excluded = fmt.Sprintf("%s is a symlink and its root doesn't have follow_symlinks set", fpath)
+			}
+			return
+		}
+		if info.IsDir() && r.onOtherFs(info) {
+			ex.Excluded = fmt.Sprintf("%s is on another filesystem and its root has one_filesystem set", fpath)
+			return
+		}
+		if last {
+			return
+		}
+		if follow {
+			d = d.linkChild(fpath)
+		} else {
+			d = d.child(fpath)
+		}
+		if info, err = os.Stat(fpath); err == nil {
+			d = d.enter(info)
+		}
+	}
+}
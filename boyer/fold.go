@@ -0,0 +1,90 @@
+package boyer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+//
+// foldMatcher matches paths containing a substring, ignoring case
+// using Unicode simple case folding: "k" matches "K" and the Kelvin
+// sign, and "σας" matches "ΣΑΣ", but "ß" does not match "ss".
+//
+// To avoid lowercasing whole blocks, it keeps a table of the first
+// byte of every case variant of the needle's first rune, scans for
+// those bytes and only does the fold comparison where one is found.
+//
+type foldMatcher struct {
+	needle []byte
+	first  [256]bool
+}
+
+func newFoldMatcher(needle string) matcher {
+	m := &foldMatcher{needle: []byte(needle)}
+	if len(needle) == 0 {
+		return literalMatcher{m.needle}
+	}
+	r, _ := utf8.DecodeRuneInString(needle)
+	var enc [utf8.UTFMax]byte
+	for f := r; ; {
+		utf8.EncodeRune(enc[:], f)
+		m.first[enc[0]] = true
+		if f = unicode.SimpleFold(f); f == r {
+			break
+		}
+	}
+	return m
+}
+
+func (m *foldMatcher) candidate(b []byte) int {
+	for i, c := range b {
+		if m.first[c] && hasPrefixFold(b[i:], m.needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *foldMatcher) match(path []byte) bool {
+	return m.candidate(path) >= 0
+}
+
+//
+// hasPrefixFold reports whether s starts with prefix, ignoring case.
+// The two can be different lengths in bytes, since case variants
+// don't always have the same length in UTF-8.
+//
+func hasPrefixFold(s, prefix []byte) bool {
+	for len(prefix) > 0 {
+		if len(s) == 0 {
+			return false
+		}
+		r1, n1 := utf8.DecodeRune(s)
+		r2, n2 := utf8.DecodeRune(prefix)
+		if r1 != r2 && !equalFoldRune(r1, r2) {
+			return false
+		}
+		s = s[n1:]
+		prefix = prefix[n2:]
+	}
+	return true
+}
+
+func equalFoldRune(r1, r2 rune) bool {
+	if r1 < utf8.RuneSelf && r2 < utf8.RuneSelf {
+		// fast path for ASCII, which only has to check a-z/A-Z
+		if 'A' <= r1 && r1 <= 'Z' {
+			r1 += 'a' - 'A'
+		}
+		if 'A' <= r2 && r2 <= 'Z' {
+			r2 += 'a' - 'A'
+		}
+		return r1 == r2
+	}
+	for f := unicode.SimpleFold(r1); f != r1; f = unicode.SimpleFold(f) {
+		if f == r2 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package boyer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+//
+// The boyer db is a sequence of blocks. Each block starts with a
+// fixed size header followed by a payload of whole records, each
+// terminated by RECORD_SEP. Records are never split across blocks,
+// so a search can run over one payload at a time. BUFSZ is only the
+// size the writer aims for: a block holding one huge record can be
+// bigger, and the reader takes the payload length from the header,
+// so it never depends on the BUFSZ the db was written with.
+//
+// Block header (all big-endian uint32):
+//   payload length in bytes
+//   number of records in the payload
+//   CRC-32 (IEEE) checksum of the payload
+//
+const BLOCK_HEADER_SZ = 12
+
+var ErrCorruptBlock = errors.New("corrupt block in db")
+
+//
+// blockWriter buffers records until it has about BUFSZ bytes of them,
+// then writes them out to w as a block.
+//
+type blockWriter struct {
+	w    io.Writer
+	buf  bytes.Buffer
+	nrec int
+}
+
+func newBlockWriter(w io.Writer) *blockWriter {
+	return &blockWriter{w: w}
+}
+
+func (bw *blockWriter) writeRecord(rec string) error {
+	// +1 to add in the size of the record separator char
+	if bw.buf.Len() > 0 && bw.buf.Len()+len(rec)+1 > BUFSZ {
+		if err := bw.flush(); err != nil {
+			return err
+		}
+	}
+	bw.buf.WriteString(rec)
+	bw.buf.WriteByte(RECORD_SEP)
+	bw.nrec++
+	return nil
+}
+
+// flush writes out any buffered records as a block
+func (bw *blockWriter) flush() error {
+	if bw.nrec == 0 {
+		return nil
+	}
+	payload := bw.buf.Bytes()
+	var hdr [BLOCK_HEADER_SZ]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(bw.nrec))
+	binary.BigEndian.PutUint32(hdr[8:12], crc32.ChecksumIEEE(payload))
+
+	if _, err := bw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := bw.w.Write(payload); err != nil {
+		return err
+	}
+	bw.buf.Reset()
+	bw.nrec = 0
+	return nil
+}
+
+//
+// blockReader reads blocks from r one at a time, checking each one
+// against its checksum.
+//
+type blockReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func newBlockReader(r io.Reader) *blockReader {
+	return &blockReader{r: r, buf: make([]byte, BUFSZ)}
+}
+
+//
+// next returns the payload of the next block and the number of records
+// in it. The payload is only valid until the next call to next.
+// Returns io.EOF when there are no more blocks.
+//
+func (br *blockReader) next() ([]byte, int, error) {
+	var hdr [BLOCK_HEADER_SZ]byte
+	if _, err := io.ReadFull(br.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, ErrCorruptBlock
+		}
+		return nil, 0, err
+	}
+	size := int(binary.BigEndian.Uint32(hdr[0:4]))
+	nrec := int(binary.BigEndian.Uint32(hdr[4:8]))
+	sum := binary.BigEndian.Uint32(hdr[8:12])
+
+	payload, err := br.readPayload(size)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, ErrCorruptBlock
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum || size == 0 || payload[size-1] != RECORD_SEP {
+		return nil, 0, ErrCorruptBlock
+	}
+	return payload, nrec, nil
+}
+
+//
+// readPayload reads the size bytes of a block payload. The size comes
+// from the block header, so it can't be trusted: past the size of the
+// buffer it has, the buffer only grows as the bytes actually arrive,
+// so a damaged header can't make it allocate far more than there is
+// in the db.
+//
+func (br *blockReader) readPayload(size int) ([]byte, error) {
+	if size <= cap(br.buf) {
+		payload := br.buf[:size]
+		_, err := io.ReadFull(br.r, payload)
+		return payload, err
+	}
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(br.r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if n < int64(size) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	br.buf = buf.Bytes()
+	return br.buf, nil
+}
+
+//
+// eachRecord calls fn with every record in a block payload, without
+// the trailing RECORD_SEP. It checks that the number of records
+// matches what the block header said.
+//
+func eachRecord(payload []byte, nrec int, fn func(rec []byte)) error {
+	n := 0
+	for len(payload) > 0 {
+		i := bytes.IndexByte(payload, RECORD_SEP)
+		fn(payload[:i])
+		payload = payload[i+1:]
+		n++
+	}
+	if n != nrec {
+		return fmt.Errorf("%v: expected %d records, found %d", ErrCorruptBlock, nrec, n)
+	}
+	return nil
+}
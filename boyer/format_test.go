@@ -0,0 +1,177 @@
+package boyer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAllRecords(t *testing.T, r io.Reader) []string {
+	var recs []string
+	br := newBlockReader(r)
+	for {
+		payload, nrec, err := br.next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		err = eachRecord(payload, nrec, func(rec []byte) {
+			recs = append(recs, string(rec))
+		})
+		if err != nil {
+			t.Fatalf("eachRecord: %v", err)
+		}
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	bw := newBlockWriter(&out)
+
+	var exp []string
+	for i := 0; i < 100000; i++ {
+		rec := "/usr/local/foo/bar/" + strings.Repeat("x", i%50)
+		exp = append(exp, rec)
+		if err := bw.writeRecord(rec); err != nil {
+			t.Fatalf("writeRecord: %v", err)
+		}
+	}
+	if err := bw.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	recs := readAllRecords(t, &out)
+	if len(recs) != len(exp) {
+		t.Fatalf("len: %v", len(recs))
+	}
+	for i := range exp {
+		if recs[i] != exp[i] {
+			t.Errorf("rec %d: %q", i, recs[i])
+		}
+	}
+}
+
+func TestBlockRecordLargerThanBufsz(t *testing.T) {
+	var out bytes.Buffer
+	bw := newBlockWriter(&out)
+
+	huge := "/" + strings.Repeat("y", BUFSZ+10)
+	exp := []string{"/a", huge, "/b"}
+	for _, rec := range exp {
+		bw.writeRecord(rec)
+	}
+	bw.flush()
+
+	recs := readAllRecords(t, &out)
+	if len(recs) != len(exp) {
+		t.Fatalf("len: %v", len(recs))
+	}
+	for i := range exp {
+		if recs[i] != exp[i] {
+			t.Errorf("rec %d has len %d", i, len(recs[i]))
+		}
+	}
+}
+
+func TestBlockChecksumMismatch(t *testing.T) {
+	var out bytes.Buffer
+	bw := newBlockWriter(&out)
+	bw.writeRecord("/usr/local/foo")
+	bw.flush()
+
+	b := out.Bytes()
+	b[BLOCK_HEADER_SZ] = 'X'
+
+	_, _, err := newBlockReader(bytes.NewReader(b)).next()
+	if err != ErrCorruptBlock {
+		t.Errorf("err: %v", err)
+	}
+}
+
+func TestBlockTruncated(t *testing.T) {
+	var out bytes.Buffer
+	bw := newBlockWriter(&out)
+	bw.writeRecord("/usr/local/foo")
+	bw.flush()
+
+	b := out.Bytes()
+	_, _, err := newBlockReader(bytes.NewReader(b[:len(b)-3])).next()
+	if err != ErrCorruptBlock {
+		t.Errorf("err: %v", err)
+	}
+}
+
+func TestBlockBadLength(t *testing.T) {
+	var out bytes.Buffer
+	bw := newBlockWriter(&out)
+	bw.writeRecord("/usr/local/foo")
+	bw.flush()
+
+	// a damaged header asks for 4GiB, with only a few bytes after it
+	b := out.Bytes()
+	binary.BigEndian.PutUint32(b[0:4], 0xffffffff)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := newBlockReader(bytes.NewReader(b)).next()
+	runtime.ReadMemStats(&after)
+	if err != ErrCorruptBlock {
+		t.Errorf("err: %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 4*BUFSZ {
+		t.Errorf("allocated %d bytes", alloc)
+	}
+}
+
+func TestHeaderRoomToUpdate(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "test.boyer")
+	// grow the roots so the first metadata JSON goes past a META_ALIGN boundary
+	var roots []string
+	for n := 0; n < 2*META_ALIGN; n += 40 {
+		roots = append(roots, "/home/someone/projects/"+strings.Repeat("r", 17))
+		file, err := os.Create(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := newDbInfo(roots, "")
+		metaSize, err := writeHeader(file, info, 0)
+		if err != nil {
+			t.Fatalf("writeHeader: %v", err)
+		}
+
+		// the final counts and duration of a long index still fit
+		info.Entries, info.Files, info.Dirs = 1e12, 9e11, 1e11
+		info.Skipped = 1e6
+		info.Duration = (100*time.Hour + 59*time.Minute + 59*time.Second + 999999999).String()
+		if _, err = writeHeader(file, info, metaSize); err != nil {
+			t.Fatalf("%d roots: writeHeader: %v", len(roots), err)
+		}
+		file.Seek(0, io.SeekStart)
+		got, err := readHeader(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("readHeader: %v", err)
+		}
+		equals(t, info.Duration, got.Duration)
+		equals(t, info.Entries, got.Entries)
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n",
+			filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
@@ -0,0 +1,150 @@
+package boyer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//
+// globMatcher matches paths against a shell glob with path.Match
+// semantics ('*' and '?' don't match '/') plus '**', which matches any
+// number of whole path segments, including none. A pattern that does
+// not start with '/' can match the trailing segments of a path, so
+// "*.go" finds .go files anywhere.
+//
+// The glob is translated to a regexp to do the matching, and the
+// longest literal piece of the glob is used to find candidates.
+//
+type globMatcher struct {
+	re      *regexp.Regexp
+	literal matcher // nil if the glob has no literal chars
+}
+
+func newGlobMatcher(glob string, foldCase bool) (matcher, error) {
+	expr, literal, err := globToRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+	if foldCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := globMatcher{re: re}
+	if literal != "" {
+		if foldCase {
+			m.literal = newFoldMatcher(literal)
+		} else {
+			m.literal = literalMatcher{[]byte(literal)}
+		}
+	}
+	return m, nil
+}
+
+func (m globMatcher) candidate(b []byte) int {
+	if m.literal == nil {
+		if len(b) == 0 {
+			return -1
+		}
+		return 0
+	}
+	return m.literal.candidate(b)
+}
+
+func (m globMatcher) match(path []byte) bool {
+	return m.re.Match(path)
+}
+
+//
+// globToRegexp returns an anchored regexp equivalent to glob and the
+// longest run of literal chars in it.
+//
+func globToRegexp(glob string) (string, string, error) {
+	var re, lit bytes.Buffer
+	var longest string
+	endLiteral := func() {
+		if lit.Len() > len(longest) {
+			longest = lit.String()
+		}
+		lit.Reset()
+	}
+
+	re.WriteString("^")
+	if !strings.HasPrefix(glob, "/") && !strings.HasPrefix(glob, "**") {
+		re.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			endLiteral()
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				startSeg := i == 0 || glob[i-1] == '/'
+				j := i + 2
+				for j < len(glob) && glob[j] == '*' {
+					j++
+				}
+				if startSeg && j == len(glob) {
+					// trailing "**": everything below
+					re.WriteString(".*")
+					i = j - 1
+					continue
+				}
+				if startSeg && glob[j] == '/' {
+					// "**/": zero or more whole segments
+					re.WriteString("(?:.*/)?")
+					i = j
+					continue
+				}
+				// "**" inside a segment is just a '*'
+				i = j - 1
+			}
+			re.WriteString("[^/]*")
+		case '?':
+			endLiteral()
+			re.WriteString("[^/]")
+		case '[':
+			endLiteral()
+			j := i + 1
+			if j < len(glob) && (glob[j] == '!' || glob[j] == '^') {
+				j++
+			}
+			if j < len(glob) && glob[j] == ']' {
+				j++
+			}
+			for j < len(glob) && glob[j] != ']' {
+				if glob[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(glob) {
+				return "", "", fmt.Errorf("unterminated '[' in glob: %s", glob)
+			}
+			class := glob[i+1 : j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, "[", `\[`, -1) + "]")
+			i = j
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			lit.WriteByte(glob[i])
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			lit.WriteByte(c)
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	endLiteral()
+	re.WriteString("$")
+	return re.String(), longest, nil
+}
@@ -0,0 +1,175 @@
+package boyer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+//
+// The boyer db starts with a header before the first block:
+//   MAGIC (8 bytes)
+//   format version (big-endian uint32)
+//   size of the metadata area that follows (big-endian uint32)
+//   length of the metadata JSON (big-endian uint32)
+//   metadata JSON, zero padded out to the size of the metadata area
+//
+// The metadata area is sized when indexing starts with at least
+// META_SPARE bytes to spare, so that the final counts and duration can
+// be written over it once indexing is done without having to move the
+// blocks after it.
+//
+const (
+	MAGIC          = "FSLOCATE"
+	FORMAT_VERSION = 3
+	MIN_VERSION    = 2 // the oldest format that can still be read: 3 only added symlink targets
+	HEADER_FIXED   = len(MAGIC) + 12
+	META_ALIGN     = 4096
+	META_SPARE     = 512 // far more than the counts and duration can grow by
+)
+
+var ErrNotDb = errors.New("not a fslocate db")
+
+//
+// DbInfo is the metadata stored in the db header
+//
+type DbInfo struct {
+	Version    int       `json:"-"`
+	Created    time.Time `json:"created"`
+	Duration   string    `json:"duration"`
+	Roots      []string  `json:"roots"`
+	IgnoreFile string    `json:"ignoreFile"`
+	IgnoreHash string    `json:"ignoreHash"`          // sha256 of the ignore file
+	DirIgnore  bool      `json:"dirIgnore,omitempty"` // per-dir ignore files read under every root
+	BlockSize  int       `json:"blockSize"`
+	Entries    int64     `json:"entries"`
+	Files      int64     `json:"files"`
+	Dirs       int64     `json:"dirs"`
+	Skipped    int       `json:"skipped"` // dirs that couldn't be read and entries that couldn't be stored
+}
+
+func newDbInfo(roots []string, ignoreFile string) *DbInfo {
+	return &DbInfo{
+		Version:    FORMAT_VERSION,
+		Created:    time.Now(),
+		Roots:      roots,
+		IgnoreFile: ignoreFile,
+		IgnoreHash: fileHash(ignoreFile),
+		BlockSize:  BUFSZ,
+	}
+}
+
+// fileHash returns the hex sha256 of a file, or "" if it can't be read
+func fileHash(fpath string) string {
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+//
+// writeHeader writes the db header for info to the start of file.
+// The first time it is called metaSize should be 0, and it picks a
+// size for the metadata area and returns it. Later calls, to update
+// info in place, must pass that size back in.
+//
+func writeHeader(file *os.File, info *DbInfo, metaSize int) (int, error) {
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return 0, err
+	}
+	if metaSize == 0 {
+		metaSize = (len(meta) + META_SPARE + META_ALIGN - 1) / META_ALIGN * META_ALIGN
+	}
+	if len(meta) > metaSize {
+		return 0, fmt.Errorf("db metadata too large for header: %d bytes", len(meta))
+	}
+
+	hdr := make([]byte, HEADER_FIXED+metaSize)
+	copy(hdr, MAGIC)
+	binary.BigEndian.PutUint32(hdr[8:12], FORMAT_VERSION)
+	binary.BigEndian.PutUint32(hdr[12:16], uint32(metaSize))
+	binary.BigEndian.PutUint32(hdr[16:20], uint32(len(meta)))
+	copy(hdr[HEADER_FIXED:], meta)
+
+	if _, err = file.WriteAt(hdr, 0); err != nil {
+		return 0, err
+	}
+	return metaSize, nil
+}
+
+//
+// readHeader reads and checks the db header from r, leaving r
+// positioned at the first block.
+//
+func readHeader(r io.Reader) (*DbInfo, error) {
+	fixed := make([]byte, HEADER_FIXED)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotDb
+		}
+		return nil, err
+	}
+	if string(fixed[:8]) != MAGIC {
+		return nil, ErrNotDb
+	}
+	version := int(binary.BigEndian.Uint32(fixed[8:12]))
+	if version < MIN_VERSION || version > FORMAT_VERSION {
+		return nil, fmt.Errorf("db format version is %d, this fslocate reads versions %d to %d",
+			version, MIN_VERSION, FORMAT_VERSION)
+	}
+	metaSize := int(binary.BigEndian.Uint32(fixed[12:16]))
+	metaLen := int(binary.BigEndian.Uint32(fixed[16:20]))
+	if metaLen > metaSize {
+		return nil, ErrNotDb
+	}
+
+	meta := make([]byte, metaSize)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return nil, ErrNotDb
+	}
+	info := &DbInfo{}
+	if err := json.Unmarshal(meta[:metaLen], info); err != nil {
+		return nil, fmt.Errorf("bad db metadata: %v", err)
+	}
+	info.Version = version
+	return info, nil
+}
+
+//
+// openDb opens the db at fpath and reads its header
+//
+func openDb(fpath string) (*os.File, *DbInfo, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := readHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %v (rebuild it with fslocate -i)", fpath, err)
+	}
+	return file, info, nil
+}
+
+//
+// Info returns the metadata from the db header
+//
+func (fl BoyerFsLocate) Info() (*DbInfo, error) {
+	fl = fl.withDefaults()
+	file, info, err := openDb(fl.DbFile)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return info, nil
+}
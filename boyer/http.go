@@ -0,0 +1,284 @@
+package boyer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quux00/fslocate/common"
+)
+
+const HTTP_DEFAULT_LIMIT = 1000 // max results per /search if the request doesn't give a limit
+
+/* ---[ HTTP API ]--- */
+
+//
+// ServeHttp reads the db into memory and serves a JSON API on addr
+// until ctx is cancelled:
+//
+//   GET  /search   search the db, see queryFromParams for the parameters
+//   GET  /stats    info about the db and the server
+//   POST /reindex  rebuild the db in the background (?update=true for -u)
+//
+// As with Serve, the db is read in again whenever it changes on disk.
+// A reindex still running when ctx is cancelled is stopped, leaving the
+// previous db as is.
+//
+func (fl BoyerFsLocate) ServeHttp(ctx context.Context, addr string, numIndexes int) error {
+	fl = fl.withDefaults()
+
+	log := fl.log()
+	db := &memDb{path: fl.DbFile, log: log}
+	if err := db.load(); err != nil {
+		return err
+	}
+	api := newHttpApi(ctx, fl, db, numIndexes)
+	srv := &http.Server{Addr: addr, Handler: api}
+
+	done := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		log.prn("Shutting down")
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+		close(done)
+	}()
+
+	log.prf("Listening on %s\n", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	<-done
+	api.reindexes.Wait()
+	return nil
+}
+
+//
+// httpApi holds the state of the HTTP API server
+//
+type httpApi struct {
+	*http.ServeMux
+	ctx        context.Context // cancelled when the server shuts down
+	fl         BoyerFsLocate
+	db         *memDb
+	numIndexes int
+	started    time.Time
+	reindexes  sync.WaitGroup
+
+	mu          sync.Mutex
+	queries     int64
+	reindexing  bool
+	lastReindex *reindexResult
+}
+
+type reindexResult struct {
+	Update   bool      `json:"update"`
+	Finished time.Time `json:"finished"`
+	Skipped  int       `json:"skipped"` // dirs that couldn't be read and entries that couldn't be stored
+	Error    string    `json:"error,omitempty"`
+}
+
+type searchResponse struct {
+	Found   int          `json:"found"`
+	More    bool         `json:"more"` // there were more than limit matches
+	Results []jsonResult `json:"results"`
+}
+
+type statsResponse struct {
+	Db          *DbInfo        `json:"db"`
+	Started     time.Time      `json:"started"`
+	Queries     int64          `json:"queries"`
+	Reindexing  bool           `json:"reindexing"`
+	LastReindex *reindexResult `json:"lastReindex,omitempty"`
+}
+
+func newHttpApi(ctx context.Context, fl BoyerFsLocate, db *memDb, numIndexes int) *httpApi {
+	api := &httpApi{
+		ServeMux:   http.NewServeMux(),
+		ctx:        ctx,
+		fl:         fl,
+		db:         db,
+		numIndexes: numIndexes,
+		started:    time.Now(),
+	}
+	api.HandleFunc("/search", api.search)
+	api.HandleFunc("/stats", api.stats)
+	api.HandleFunc("/reindex", api.reindex)
+	return api
+}
+
+func (api *httpApi) search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	q, err := queryFromParams(r.URL.Query())
+	if err == nil {
+		_, err = newMatcher(q)
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.mu.Lock()
+	api.queries++
+	api.mu.Unlock()
+
+	// ask for one more than the limit to know if there are more
+	limit := q.Limit
+	q.Limit++
+	resp := searchResponse{Results: []jsonResult{}}
+	nfound, err := api.db.search(r.Context(), q, func(path, rec []byte) {
+		if len(resp.Results) < limit {
+			resp.Results = append(resp.Results, newJsonResult(path, rec))
+		}
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp.Found = len(resp.Results)
+	resp.More = nfound > limit
+	api.fl.log().prf("HTTP query %q: %d matches\n", q.Terms, resp.Found)
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (api *httpApi) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	if err := api.db.load(); err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	api.db.mu.RLock()
+	resp := statsResponse{Db: api.db.info, Started: api.started}
+	api.db.mu.RUnlock()
+
+	api.mu.Lock()
+	resp.Queries = api.queries
+	resp.Reindexing = api.reindexing
+	resp.LastReindex = api.lastReindex
+	api.mu.Unlock()
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (api *httpApi) reindex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	update, _ := strconv.ParseBool(r.URL.Query().Get("update"))
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.reindexing {
+		httpError(w, http.StatusConflict, "already reindexing")
+		return
+	}
+	api.reindexing = true
+	api.reindexes.Add(1)
+	go api.runReindex(update)
+	writeJson(w, http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+//
+// runReindex rebuilds the db. The next query loads the new db, since
+// memDb notices it has changed on disk.
+//
+func (api *httpApi) runReindex(update bool) {
+	defer api.reindexes.Done()
+	api.fl.log().prf("Reindexing (update: %v)\n", update)
+	_, failures, err := api.fl.Index(api.ctx, api.numIndexes, update)
+	res := &reindexResult{Update: update, Finished: time.Now(), Skipped: len(failures)}
+	if err != nil {
+		api.fl.log().warnf("Reindex failed: %v\n", err)
+		res.Error = err.Error()
+	}
+
+	api.mu.Lock()
+	api.reindexing = false
+	api.lastReindex = res
+	api.mu.Unlock()
+}
+
+//
+// queryFromParams builds a query from the URL parameters of a /search
+// request. They mirror the command line options:
+//
+//   q=TERM       search term (can be repeated)
+//   x=TERM       leave out entries matching TERM (can be repeated)
+//   any=true     match any of the terms, not all (-o)
+//   regex=true   the terms are regular expressions (-r)
+//   glob=true    the terms are shell globs (-g)
+//   basename=true  match against basenames only (-b)
+//   icase=true   ignore case (-I)
+//   limit=N      return at most N matches (default HTTP_DEFAULT_LIMIT)
+//   type, size   the same as -type and -size
+//   newer=DATE   the same as -newer, but only a date: the server's
+//                files are not for clients to look at
+//
+func queryFromParams(v url.Values) (common.Query, error) {
+	q := common.Query{Terms: v["q"], Exclude: v["x"], Limit: HTTP_DEFAULT_LIMIT}
+
+	bools := map[string]*bool{
+		"any":      &q.Any,
+		"regex":    &q.Regex,
+		"glob":     &q.Glob,
+		"basename": &q.Basename,
+		"icase":    &q.FoldCase,
+	}
+	for name, b := range bools {
+		if s := v.Get(name); s != "" {
+			var err error
+			if *b, err = strconv.ParseBool(s); err != nil {
+				return q, fmt.Errorf("%s: not true or false: %q", name, s)
+			}
+		}
+	}
+
+	var err error
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 {
+			return q, fmt.Errorf("limit: not a positive number: %q", s)
+		}
+	}
+	if q.Types, err = common.ParseTypes(v.Get("type")); err != nil {
+		return q, fmt.Errorf("type: %v", err)
+	}
+	if s := v.Get("size"); s != "" {
+		if q.Size, err = common.ParseSizeFilter(s); err != nil {
+			return q, fmt.Errorf("size: %v", err)
+		}
+	}
+	if s := v.Get("newer"); s != "" {
+		if q.Newer, err = common.ParseDate(s); err != nil {
+			return q, fmt.Errorf("newer: %v", err)
+		}
+	}
+
+	if len(q.Terms) == 0 && len(q.Exclude) == 0 && q.Types == "" && q.Size == nil && q.Newer.IsZero() {
+		return q, errors.New("no search term provided")
+	}
+	return q, nil
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	writeJson(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,72 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package boyer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHttpReindex(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{"a.txt": "", "sub/b.txt": ""})
+	writeTree(t, dir, map[string]string{"ignore": ""})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+	}
+	writeTestDb(t, fl.DbFile,
+		dirListing{dir: testEntries[0], files: testEntries[1:3]},
+		dirListing{dir: testEntries[3], files: testEntries[4:]})
+
+	// the reindex waits to read the index file until the test writes it
+	if err := syscall.Mkfifo(fl.IndexFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newHttpApi(context.Background(), fl, &memDb{path: fl.DbFile}, 2))
+	t.Cleanup(srv.Close)
+
+	var resp map[string]string
+	requestJson(t, http.MethodPost, srv.URL+"/reindex", http.StatusAccepted, &resp)
+	requestJson(t, http.MethodPost, srv.URL+"/reindex", http.StatusConflict, &resp)
+	equals(t, "already reindexing", resp["error"])
+	var stats statsResponse
+	getJson(t, srv.URL+"/stats", http.StatusOK, &stats)
+	equals(t, true, stats.Reindexing)
+	equals(t, int64(6), stats.Db.Entries)
+
+	if err := os.WriteFile(fl.IndexFile, []byte(root+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); stats.Reindexing; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("reindex did not finish")
+		}
+		stats = statsResponse{}
+		getJson(t, srv.URL+"/stats", http.StatusOK, &stats)
+	}
+	if stats.LastReindex == nil {
+		t.Fatal("no lastReindex after the reindex")
+	}
+	equals(t, "", stats.LastReindex.Error)
+	equals(t, false, stats.LastReindex.Update)
+	equals(t, 0, stats.LastReindex.Skipped)
+
+	// the new db is what is served now
+	equals(t, []string{root}, stats.Db.Roots)
+	equals(t, int64(4), stats.Db.Entries)
+	equals(t, int64(2), stats.Db.Dirs)
+	var found searchResponse
+	getJson(t, srv.URL+"/search?q=b.txt", http.StatusOK, &found)
+	equals(t, 1, found.Found)
+	equals(t, filepath.Join(root, "sub/b.txt"), found.Results[0].Path)
+}
@@ -0,0 +1,94 @@
+package boyer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func newTestApi(t *testing.T) *httptest.Server {
+	dbpath := filepath.Join(t.TempDir(), "test.boyer")
+	lsts := []dirListing{
+		{dir: testEntries[0], files: testEntries[1:3]},
+		{dir: testEntries[3], files: testEntries[4:]},
+	}
+	if _, err := writeDb(context.Background(), logger{}, dbpath, &DbInfo{Roots: []string{"/usr/local", "/home"}}, feedListings(lsts)); err != nil {
+		t.Fatalf("writeDb: %v", err)
+	}
+	srv := httptest.NewServer(newHttpApi(context.Background(), BoyerFsLocate{DbFile: dbpath}, &memDb{path: dbpath}, 1))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getJson(t *testing.T, url string, status int, v interface{}) {
+	requestJson(t, http.MethodGet, url, status, v)
+}
+
+// requestJson sends a request with no body, checks its status and decodes the JSON reply into v
+func requestJson(t *testing.T, method, url string, status int, v interface{}) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Errorf("%s %s: status %d, expected %d", method, url, resp.StatusCode, status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+}
+
+func TestHttpSearch(t *testing.T) {
+	srv := newTestApi(t)
+
+	var resp searchResponse
+	getJson(t, srv.URL+"/search?q=go&type=f", http.StatusOK, &resp)
+	var paths []string
+	for _, res := range resp.Results {
+		paths = append(paths, res.Path)
+	}
+	equals(t, []string{"/usr/local/go/README.md", "/usr/local/go/api/go1.txt", "/home/quux00/golang/main.go"}, paths)
+	equals(t, 3, resp.Found)
+	equals(t, false, resp.More)
+	equals(t, int64(200), resp.Results[2].Size)
+
+	resp = searchResponse{}
+	getJson(t, srv.URL+"/search?q=go&limit=2", http.StatusOK, &resp)
+	equals(t, 2, resp.Found)
+	equals(t, true, resp.More)
+
+	var errResp map[string]string
+	getJson(t, srv.URL+"/search?q=(&regex=true", http.StatusBadRequest, &errResp)
+	getJson(t, srv.URL+"/search?type=f&limit=x", http.StatusBadRequest, &errResp)
+	getJson(t, srv.URL+"/search", http.StatusBadRequest, &errResp)
+	equals(t, "no search term provided", errResp["error"])
+
+	// newer takes a date, but not a file on the server as -newer does
+	getJson(t, srv.URL+"/search?newer=2000-01-01", http.StatusOK, &searchResponse{})
+	getJson(t, srv.URL+"/search?newer=/", http.StatusBadRequest, &errResp)
+	equals(t, `newer: not a date: "/"`, errResp["error"])
+}
+
+func TestHttpStats(t *testing.T) {
+	srv := newTestApi(t)
+	getJson(t, srv.URL+"/search?q=notes", http.StatusOK, &searchResponse{})
+
+	var resp statsResponse
+	getJson(t, srv.URL+"/stats", http.StatusOK, &resp)
+	equals(t, int64(6), resp.Db.Entries)
+	equals(t, int64(2), resp.Db.Dirs)
+	equals(t, []string{"/usr/local", "/home"}, resp.Db.Roots)
+	equals(t, int64(1), resp.Queries)
+	equals(t, false, resp.Reindexing)
+
+	var errResp map[string]string
+	getJson(t, srv.URL+"/reindex", http.StatusMethodNotAllowed, &errResp)
+}
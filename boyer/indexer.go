@@ -2,139 +2,463 @@ package boyer
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
 )
 
-var verbose bool
-
 const (
 	OUT_FILE   = "db/fslocate.boyer"
 	INDEX_FILE = "conf/fslocate.indexlist"
 	PATH_SEP   = string(os.PathSeparator)
-	BUFSZ      = 2097152 // 2MiB cache before flush to disk
+	BUFSZ      = 2097152 // 2MiB target size of a block before flush to disk
 	RECORD_SEP = 0x1e    // "Record Separator" char in ASCII
+
+	LISTING_BUFSZ = 1024 // dir listings buffered between indexers and writer
 )
 
-type BoyerFsLocate struct{}
+//
+// BoyerFsLocate is the boyer implementation of fslocate. Its fields say
+// where the db and config files are; any left empty default to OUT_FILE,
+// INDEX_FILE and common.IgnoreFile. If ConfigFile is set and exists, the
+// roots and ignore rules are read from it instead of the index and ignore
+// files. Warnings, if set, is where warnings about what couldn't be
+// read or done go. Verbose turns on progress output to stdout.
+//
+type BoyerFsLocate struct {
+	DbFile     string
+	ConfigFile string
+	IndexFile  string
+	IgnoreFile string
+	DirIgnore  bool // read the per-dir ignore files (DIR_IGNORE_FILES) under every root
+	Warnings   io.Writer
+	Verbose    bool
+}
+
+// withDefaults fills in the default for each path not set
+func (fl BoyerFsLocate) withDefaults() BoyerFsLocate {
+	if fl.DbFile == "" {
+		fl.DbFile = OUT_FILE
+	}
+	if fl.IndexFile == "" {
+		fl.IndexFile = INDEX_FILE
+	}
+	if fl.IgnoreFile == "" {
+		fl.IgnoreFile = common.IgnoreFile
+	}
+	return fl
+}
+
+// log returns the logger for fl's progress output and warnings
+func (fl BoyerFsLocate) log() logger {
+	return logger{verbose: fl.Verbose, warn: fl.Warnings}
+}
 
 /* ---[ INDEX ]--- */
 
-func (_ BoyerFsLocate) Index(numIndexes int, beVerbose bool) {
-	verbose = beVerbose
+//
+// Index walks the top level dirs in the config and writes a new db.
+// If update is true, the previous db is read in first and any dir whose
+// mtime has not changed since then is not read again: its entries are
+// copied over from the previous db.
+//
+// A dir that cannot be read (permissions, deleted mid-walk, etc.) is
+// skipped and the walk carries on. The errors for all skipped dirs are
+// returned along with the header of the new db, so no failures means
+// the db is complete. An error is returned if the db can't be written,
+// or ctx's error if it is cancelled first. Either way the previous db
+// is left as is.
+//
+func (fl BoyerFsLocate) Index(ctx context.Context, numIndexes int, update bool) (*DbInfo, []error, error) {
+	fl = fl.withDefaults()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	tmpOut := OUT_FILE + common.RandVal()
-	prn("Temp out file: " + tmpOut)
-	file, err := os.Create(tmpOut)
+	roots, ignoreFile, err := fl.loadRoots()
 	if err != nil {
-		log.Fatalf("ERROR: %v\n", err)
+		return nil, nil, err
 	}
-	defer os.Remove(tmpOut)
-	defer file.Close()
+	log := fl.log()
+	log.prf("Read in %d top level entries\n", len(roots))
+	info := newDbInfo(rootPaths(roots), ignoreFile)
+	info.DirIgnore = fl.DirIgnore
 
-	queue := make([]string, 0, 10000)
-	queue = getTopLevelEntries(queue)
-	prf("Read in %d top level entries\n", len(queue))
-	ignorePats := common.ReadInIgnorePatterns()
-
-	var buf bytes.Buffer
-	for len(queue) > 0 {
-		// pull off front of queue
-		dir := queue[0]
-		queue = queue[1:]
-		prf("Procesing dir: %s\n", dir)
-		err := writeEntry(&buf, file, dir)
-		if err != nil {
-			log.Fatalf("ERROR: %v\n", err)
+	var prevDirs map[string]*prevDir
+	if update {
+		prevDirs = readPrevDirs(log, fl.DbFile, info)
+	}
+
+	var report *pruneReport
+	if fl.Verbose {
+		report = newPruneReport(log)
+	}
+	// the indexers block on listings once the writer stops reading it,
+	// so if writing fails they have to be stopped and drained
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listings := walk(walkCtx, log, startDirs(roots), prevDirs, report, numIndexes)
+	failures, err := writeDb(ctx, log, fl.DbFile, info, listings)
+	if err != nil {
+		cancel()
+		for range listings {
+		}
+		if err == ctx.Err() {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("Unable to write db %s: %v", fl.DbFile, err)
+	}
+	report.print()
+	return info, failures, nil
+}
+
+//
+// walk starts numIndexes indexer goroutines walking the dirs under
+// start and returns the channel they send their dir listings on.
+// The channel is closed once the walk is done or ctx is cancelled.
+// What the ignore rules leave out is recorded in report, if not nil.
+//
+func walk(ctx context.Context, log logger, start []queuedDir, prevDirs map[string]*prevDir,
+	report *pruneReport, numIndexes int) <-chan dirListing {
+
+	if numIndexes < 1 {
+		numIndexes = 1
+	}
+	log.prf("Starting %d indexers\n", numIndexes)
+
+	queue := newDirQueue(start)
+	listings := make(chan dirListing, LISTING_BUFSZ)
+	var wg sync.WaitGroup
+	for i := 0; i < numIndexes; i++ {
+		wg.Add(1)
+		go indexer(ctx, log, queue, prevDirs, report, listings, &wg)
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(listings)
+		close(finished)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			// stops the indexers waiting for more work
+			queue.cancel()
+		case <-finished:
 		}
+	}()
+	return listings
+}
+
+//
+// writeDb writes the dir listings to a new db at fpath, filling in the
+// counts in info as it goes. The db is written to a temp file that
+// replaces fpath once it is complete, so the previous db is left as is if
+// anything goes wrong or ctx is cancelled. Returns the errors of the
+// dirs that could not be read, and an error if the db itself could not
+// be written.
+//
+func writeDb(ctx context.Context, log logger, fpath string, info *DbInfo,
+	listings <-chan dirListing) (failures []error, err error) {
 
-		var entries []os.FileInfo
-		entries, err = ioutil.ReadDir(dir)
+	if err = os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return nil, err
+	}
+	tmpOut := fpath + common.RandVal()
+	log.prn("Temp out file: " + tmpOut)
+	file, err := os.Create(tmpOut)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
 		if err != nil {
-			log.Fatalf("ERROR: %v\n", err)
+			file.Close()
+			os.Remove(tmpOut)
 		}
+	}()
 
-		// processEntries(dir, entries, ignorePats, dirChan, file)
-		for _, e := range entries {
-			fullpath := common.CreateFullPath(dir, e.Name())
-			if !common.ShouldIgnore(ignorePats, fullpath) {
-				if e.IsDir() {
-					queue = append(queue, fullpath)
-				} else {
-					prf("Writing entry: %s\n", fullpath)
-					err := writeEntry(&buf, file, fullpath)
-					if err != nil {
-						log.Fatalf("ERROR: %v\n", err)
-					}
-				}
+	metaSize, err := writeHeader(file, info, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(int64(HEADER_FIXED+metaSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// this goroutine is the single writer to the db file
+	bw := newBlockWriter(file)
+	for lst := range listings {
+		if err = ctx.Err(); err != nil {
+			log.prn("Cancelled: removing " + tmpOut)
+			return nil, err
+		}
+		if lst.err != nil {
+			log.prf("Skipping dir: %v\n", lst.err)
+			failures = append(failures, lst.err)
+			if lst.missing {
+				continue
+			}
+		}
+		for _, err := range lst.skipped {
+			log.prf("Skipping entry: %v\n", err)
+			failures = append(failures, err)
+		}
+		log.prf("Writing dir: %s\n", lst.dir.Path)
+		info.Dirs++
+		if err = bw.writeRecord(encodeRecord(lst.dir)); err != nil {
+			return nil, err
+		}
+		for _, e := range lst.files {
+			log.prf("Writing entry: %s\n", e.Path)
+			info.Files++
+			if err = bw.writeRecord(encodeRecord(e)); err != nil {
+				return nil, err
 			}
 		}
 	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = bw.flush(); err != nil {
+		return nil, err
+	}
 
-	padToLimit(&buf)
-	flushBuffer(&buf, file)
+	info.Entries = info.Dirs + info.Files
+	info.Skipped = len(failures)
+	info.Duration = time.Since(info.Created).String()
+	if _, err = writeHeader(file, info, metaSize); err != nil {
+		return nil, err
+	}
+	if err = file.Sync(); err != nil {
+		return nil, err
+	}
+	if err = file.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmpOut, fpath); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
 
-	file.Close()
-	os.Remove(OUT_FILE)
-	err = os.Rename(tmpOut, OUT_FILE)
+//
+// readPrevDirs reads in the dirs from the previous db for an incremental
+// index. If the roots or ignore file have changed since the previous db
+// was written, its contents can't be reused and nil is returned.
+//
+func readPrevDirs(log logger, dbFile string, info *DbInfo) map[string]*prevDir {
+	prevDirs, prevInfo, err := readPrevDb(dbFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Unable to copy new boyer db to %s: %v\n", OUT_FILE, err)
-		return
+		log.warnf("Unable to read previous db: %v\n", err)
+		return nil
+	}
+	if prevDirs == nil {
+		log.prn("No previous db found: doing a full index")
+		return nil
+	}
+	if !sameStrings(prevInfo.Roots, info.Roots) || prevInfo.IgnoreHash != info.IgnoreHash ||
+		prevInfo.DirIgnore != info.DirIgnore {
+		log.prn("Roots or ignore file changed since previous db: doing a full index")
+		return nil
 	}
+	log.prf("Read in %d dirs from previous db\n", len(prevDirs))
+	return prevDirs
 }
 
-// TODO: haven't dealt with case where len(entry) > BUFSZ
-func writeEntry(buf *bytes.Buffer, file *os.File, entry string) error {
-	// +1 to add in the size of the record separator char
-	if buf.Len()+len(entry)+1 > BUFSZ {
-		prf("writeEntry: PadToLimit called for entry: %s\n", entry)
-		padToLimit(buf)
-		flushBuffer(buf, file)
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
 
-	_, err := buf.WriteString(entry)
-	if err != nil {
-		return err
+//
+// indexer pulls directories off the shared queue until the walk is
+// complete or ctx is cancelled. Subdirectories are pushed back onto
+// the queue and each directory is handed to the writer along with the
+// files directly inside it, so a dir and its files are always
+// contiguous in the db. Dirs found in prevDirs with an unchanged mtime
+// are not read again, unless a per-dir ignore file above them has
+// changed. The contents of dirs at the max depth for their root are
+// left out. Ignored dirs are pruned: they are never queued, so nothing
+// under them is looked at. Nor are other roots nested under the root:
+// they are walked from their own entries. Symlinks to dirs are followed
+// if the root says to, other than those that would make a cycle.
+//
+func indexer(ctx context.Context, log logger, queue *dirQueue, prevDirs map[string]*prevDir,
+	report *pruneReport, out chan<- dirListing, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	for {
+		d, ok := queue.pop()
+		if !ok {
+			return
+		}
+		log.prf("Procesing dir: %s\n", d.path)
+
+		lst := dirListing{dir: fsentry.E{Path: d.path, Typ: fsentry.DIR}, linked: d.linked}
+		info, err := os.Stat(d.path)
+		if err == nil {
+			lst.dir = fsentry.New(d.path, info)
+			if d.link {
+				lst.dir.Target, _ = os.Readlink(d.path)
+			}
+			err = checkStorable(lst.dir)
+		}
+		if err != nil {
+			lst.err = err
+			lst.missing = true
+		} else {
+			d = d.enter(info)
+			prev := prevDirs[d.path]
+			if d.root.atMaxDepth(d.depth) {
+				log.prf("At max depth: %s\n", d.path)
+			} else {
+				d.ignores = readDirIgnores(log, d, prev)
+				lst.ignores = d.ignores
+				if prev != nil && prev.mtime == lst.dir.Mtime && !d.ignores.isStale() {
+					log.prf("Unchanged dir: %s\n", d.path)
+					reuseEntries(log, queue, d, prev, report, &lst)
+				} else {
+					readEntries(log, queue, d, report, &lst)
+				}
+			}
+		}
+		select {
+		case out <- lst:
+		case <-ctx.Done():
+			// the writer has stopped reading
+		}
+		queue.done()
 	}
-	_, err = buf.WriteRune(RECORD_SEP)
+}
+
+//
+// readEntries fills in lst from the dir on disk. If the dir can't be
+// read, lst.err is set and its mtime set to UNKNOWN_MTIME, so that the
+// next incremental index will try to read it again. Files the db can't
+// store are left out and listed in lst.skipped.
+//
+func readEntries(log logger, queue *dirQueue, d queuedDir, report *pruneReport, lst *dirListing) {
+	entries, err := ioutil.ReadDir(lst.dir.Path)
 	if err != nil {
-		return err
+		lst.err = err
+		lst.dir.Mtime = UNKNOWN_MTIME
+		return
 	}
 
-	if buf.Len() == BUFSZ {
-		flushBuffer(buf, file)
+	for _, e := range entries {
+		fullpath := common.CreateFullPath(lst.dir.Path, e.Name())
+		follow := e.Mode()&os.ModeSymlink != 0 && d.followLink(log, fullpath)
+		isDir := e.IsDir() || follow
+		if isDir && d.root.isInner(fullpath) {
+			log.prf("Leaving nested root to itself: %s\n", fullpath)
+			continue
+		}
+		if rule := d.ignoredBy(fullpath, isDir); rule != nil {
+			report.add(rule, fullpath, isDir)
+			continue
+		}
+		switch {
+		case follow:
+			log.prf("Following symlink: %s\n", fullpath)
+			queue.push(d.linkChild(fullpath))
+		case e.IsDir():
+			if d.root.onOtherFs(e) {
+				log.prf("Not crossing into other filesystem: %s\n", fullpath)
+				continue
+			}
+			queue.push(d.child(fullpath))
+		default:
+			fe := fsentry.New(fullpath, e)
+			if err := checkStorable(fe); err != nil {
+				lst.skipped = append(lst.skipped, err)
+				continue
+			}
+			lst.files = append(lst.files, fe)
+		}
 	}
-	return nil
 }
 
-func padToLimit(buf *bytes.Buffer) {
-	var diff = BUFSZ - buf.Len()
-	for i := 0; i < diff; i++ {
-		buf.WriteRune(RECORD_SEP)
+//
+// reuseEntries fills in lst from what the previous db recorded for
+// the dir. The ignore patterns are applied again in case they have
+// been added to since the previous run. The files are looked at again,
+// though the dir isn't read: changing a file's contents doesn't change
+// the mtime of the dir it is in, so their size and mtime may not be
+// what the previous db has.
+//
+func reuseEntries(log logger, queue *dirQueue, d queuedDir, prev *prevDir, report *pruneReport, lst *dirListing) {
+	for _, sub := range prev.subdirs {
+		if d.root.isInner(sub) {
+			continue
+		}
+		if rule := d.ignoredBy(sub, true); rule != nil {
+			report.add(rule, sub, true)
+			continue
+		}
+		// mounting a filesystem or repointing a symlink doesn't change
+		// the mtime of the dir
+		if d.root.OneFilesystem || d.root.FollowSymlinks {
+			info, err := os.Lstat(sub)
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if d.followLink(log, sub) {
+					queue.push(d.linkChild(sub))
+				} else {
+					lst.files = append(lst.files, fsentry.New(sub, info))
+				}
+				continue
+			}
+			if err == nil && d.root.onOtherFs(info) {
+				log.prf("Not crossing into other filesystem: %s\n", sub)
+				continue
+			}
+		}
+		queue.push(d.child(sub))
+	}
+	for _, e := range prev.files {
+		if rule := d.ignoredBy(e.Path, e.Typ == fsentry.DIR); rule != nil {
+			report.add(rule, e.Path, false)
+			continue
+		}
+		info, err := os.Lstat(e.Path)
+		if err != nil {
+			// removed since the dir was looked at
+			continue
+		}
+		fe := fsentry.New(e.Path, info)
+		if err = checkStorable(fe); err != nil {
+			// a symlink repointed to a name the db can't store
+			lst.skipped = append(lst.skipped, err)
+			continue
+		}
+		lst.files = append(lst.files, fe)
 	}
 }
 
-func flushBuffer(buf *bytes.Buffer, file *os.File) error {
-	_, err := file.Write(buf.Bytes())
-	file.Sync()
-	buf.Reset()
-	return err
-}
-
-func getTopLevelEntries(queue []string) []string {
-	if !common.FileExists(INDEX_FILE) {
-		log.Fatal("ERROR: Cannot find file " + INDEX_FILE)
+func getTopLevelEntries(indexFile string, queue []string) ([]string, error) {
+	if !common.FileExists(indexFile) {
+		return nil, errors.New("Cannot find file " + indexFile)
 	}
 
-	file, err := os.Open(INDEX_FILE)
+	file, err := os.Open(indexFile)
 	if err != nil {
-		log.Fatal("ERROR: Cannot open file " + INDEX_FILE)
+		return nil, errors.New("Cannot open file " + indexFile)
 	}
 	defer file.Close()
 
@@ -146,27 +470,40 @@ func getTopLevelEntries(queue []string) []string {
 		}
 	}
 	if err = scnr.Err(); err != nil {
-		log.Fatalf("ERROR while reading %s: %v\n", INDEX_FILE, err)
+		return nil, fmt.Errorf("Error while reading %s: %v", indexFile, err)
 	}
-	return queue
+	return queue, nil
+}
+
+//
+// logger prints the progress output of verbose mode to stdout and
+// warnings to warn, if set. The zero logger prints nothing.
+//
+type logger struct {
+	verbose bool
+	warn    io.Writer
+}
+
+func (l logger) warnf(format string, vals ...interface{}) {
+	common.Warnf(l.warn, format, vals...)
 }
 
-func pr(s string) {
-	if verbose {
+func (l logger) pr(s string) {
+	if l.verbose {
 		fmt.Print(s)
 		os.Stdout.Sync()
 	}
 }
 
-func prn(s string) {
-	if verbose {
+func (l logger) prn(s string) {
+	if l.verbose {
 		fmt.Println(s)
 		os.Stdout.Sync()
 	}
 }
 
-func prf(format string, vals ...interface{}) {
-	if verbose {
+func (l logger) prf(format string, vals ...interface{}) {
+	if l.verbose {
 		fmt.Printf(format, vals...)
 		os.Stdout.Sync()
 	}
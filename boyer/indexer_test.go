@@ -0,0 +1,476 @@
+package boyer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+func TestWriteDbCancelled(t *testing.T) {
+	dir := t.TempDir()
+	dbpath := filepath.Join(dir, "test.boyer")
+	lsts := []dirListing{{dir: testEntries[0], files: testEntries[1:3]}}
+	if _, err := writeDb(context.Background(), logger{}, dbpath, &DbInfo{}, feedListings(lsts)); err != nil {
+		t.Fatalf("writeDb: %v", err)
+	}
+	prev, _ := ioutil.ReadFile(dbpath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lsts = append(lsts, dirListing{dir: testEntries[3], files: testEntries[4:]})
+	_, err := writeDb(ctx, logger{}, dbpath, &DbInfo{}, feedListings(lsts))
+	equals(t, context.Canceled, err)
+
+	// the previous db is untouched and the temp file is gone
+	cur, _ := ioutil.ReadFile(dbpath)
+	equals(t, string(prev), string(cur))
+	files, _ := ioutil.ReadDir(dir)
+	equals(t, 1, len(files))
+}
+
+func TestWalkCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	start := startDirs([]*root{newRoot(logger{}, common.RootConfig{Path: t.TempDir()}, nil, nil)})
+	listings := walk(ctx, logger{}, start, nil, nil, 2)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range listings {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("walk did not stop when cancelled")
+	}
+}
+
+func TestIndexWriteFails(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	// more dirs than the listings buffer holds, so the indexers block
+	tree := map[string]string{"indexlist": root, "ignore": "", "notadir": ""}
+	for i := 0; i < LISTING_BUFSZ+100; i++ {
+		tree[fmt.Sprintf("root/d%d/f", i)] = ""
+	}
+	writeTree(t, dir, tree)
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "notadir", "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+	}
+	before := runtime.NumGoroutine()
+	if _, _, err := fl.Index(context.Background(), 2, false); err == nil {
+		t.Fatal("Index should fail to write the db")
+	}
+	// the walk's goroutines all exit
+	for start := time.Now(); runtime.NumGoroutine() > before; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d goroutines left running", runtime.NumGoroutine()-before)
+		}
+	}
+}
+
+func TestDirQueueCancel(t *testing.T) {
+	q := newDirQueue([]queuedDir{{path: "/a"}})
+	dir, ok := q.pop()
+	equals(t, "/a", dir.path)
+	equals(t, true, ok)
+
+	// /a is still pending, so this would wait forever without cancel
+	popped := make(chan bool)
+	go func() {
+		_, ok := q.pop()
+		popped <- ok
+	}()
+	q.cancel()
+	equals(t, false, <-popped)
+}
+
+// writeTree creates the files in contents under dir
+func writeTree(t *testing.T, dir string, contents map[string]string) {
+	for name, content := range contents {
+		fpath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// indexedPaths returns the paths in fl's db, relative to root
+func indexedPaths(t *testing.T, fl BoyerFsLocate, root string) []string {
+	var paths []string
+	_, err := fl.Find(context.Background(), common.Query{Terms: []string{root}}, func(e fsentry.E) {
+		paths = append(paths, strings.TrimPrefix(strings.TrimPrefix(e.Path, root), "/"))
+	})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// dbRecords returns the records in the db at fpath, sorted
+func dbRecords(t *testing.T, fpath string) []string {
+	file, _, err := openDb(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	recs := readAllRecords(t, file)
+	sort.Strings(recs)
+	return recs
+}
+
+//
+// markUnread rewrites the db at fpath as if dir couldn't be read when
+// it was indexed: its files are left out and its mtime is unknown
+//
+func markUnread(t *testing.T, fpath, dir string) {
+	file, info, err := openDb(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lsts []dirListing
+	for _, rec := range readAllRecords(t, file) {
+		e, err := decodeRecord([]byte(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case e.Typ == fsentry.DIR && e.Path == dir:
+			e.Mtime = UNKNOWN_MTIME
+			lsts = append(lsts, dirListing{dir: e})
+		case e.Typ == fsentry.DIR:
+			lsts = append(lsts, dirListing{dir: e})
+		case filepath.Dir(e.Path) != dir:
+			lsts[len(lsts)-1].files = append(lsts[len(lsts)-1].files, e)
+		}
+	}
+	file.Close()
+	if _, err = writeDb(context.Background(), logger{}, fpath, info, feedListings(lsts)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalIndex(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{
+		"same/a.txt":     "",
+		"same/sub/b.txt": "",
+		"grows/c.txt":    "",
+		"gone/d.txt":     "",
+		"gone/sub/e.txt": "",
+		"unread/f.txt":   "",
+	})
+	writeTree(t, dir, map[string]string{"indexlist": root, "ignore": ""})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+	}
+	if _, _, err := fl.Index(context.Background(), 2, false); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	markUnread(t, fl.DbFile, filepath.Join(root, "unread"))
+	equals(t, []string{"", "gone", "gone/d.txt", "gone/sub", "gone/sub/e.txt", "grows", "grows/c.txt",
+		"same", "same/a.txt", "same/sub", "same/sub/b.txt", "unread"}, indexedPaths(t, fl, root))
+
+	writeTree(t, root, map[string]string{"grows/new.txt": ""})
+	// a file rewritten in a dir that is otherwise unchanged
+	writeTree(t, root, map[string]string{"same/a.txt": "rewritten"})
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(root, "same/a.txt"), later, later)
+	os.Chtimes(filepath.Join(root, "grows"), later, later)
+	if err := os.RemoveAll(filepath.Join(root, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fl.Index(context.Background(), 2, true); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	equals(t, []string{"", "grows", "grows/c.txt", "grows/new.txt", "same", "same/a.txt", "same/sub",
+		"same/sub/b.txt", "unread", "unread/f.txt"}, indexedPaths(t, fl, root))
+
+	// the same as a full index
+	full := fl
+	full.DbFile = filepath.Join(dir, "full.boyer")
+	if _, _, err := full.Index(context.Background(), 2, false); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	equals(t, dbRecords(t, full.DbFile), dbRecords(t, fl.DbFile))
+}
+
+func TestUnstorableNames(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{"ok": "", "bad\x1ename": "", "d\x1fir/x": "", "sub/y": ""})
+	if err := os.Symlink("t\x1e", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dir, map[string]string{"indexlist": root, "ignore": ""})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+	}
+	// left out and reported, without spoiling the db for -u, which
+	// doesn't report them again as it doesn't read the unchanged dir
+	for _, update := range []bool{false, true} {
+		info, failures, err := fl.Index(context.Background(), 2, update)
+		if err != nil {
+			t.Fatalf("Index: %v", err)
+		}
+		exp := 3
+		if update {
+			exp = 0
+		}
+		equals(t, exp, len(failures))
+		equals(t, exp, info.Skipped)
+		equals(t, []string{"", "ok", "sub", "sub/y"}, indexedPaths(t, fl, root))
+		if _, _, err = readPrevDb(fl.DbFile); err != nil {
+			t.Fatalf("readPrevDb: %v", err)
+		}
+	}
+}
+
+func TestDirIgnore(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{
+		".gitignore":           "build/\n*.log\n",
+		"a.log":                "",
+		"b.txt":                "",
+		"build/out.o":          "",
+		"sub/build/out.o":      "",
+		"proj/.gitignore":      "/gen/\n",
+		"proj/.fslocateignore": "!keep.log\n",
+		"proj/gen/x.go":        "",
+		"proj/src/gen/y.go":    "",
+		"proj/keep.log":        "",
+		"proj/drop.log":        "",
+	})
+	writeTree(t, dir, map[string]string{"indexlist": root, "ignore": ""})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+		DirIgnore:  true,
+	}
+	if _, _, err := fl.Index(context.Background(), 2, false); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	equals(t, []string{"", ".gitignore", "b.txt", "proj", "proj/.fslocateignore", "proj/.gitignore",
+		"proj/keep.log", "proj/src", "proj/src/gen", "proj/src/gen/y.go", "sub"}, indexedPaths(t, fl, root))
+
+	// a changed ignore file is noticed by an update even though its dir isn't
+	gitignore := filepath.Join(root, ".gitignore")
+	if err := ioutil.WriteFile(gitignore, []byte("build/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(gitignore, later, later)
+	if _, _, err := fl.Index(context.Background(), 2, true); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	equals(t, []string{"", ".gitignore", "a.log", "b.txt", "proj", "proj/.fslocateignore", "proj/.gitignore",
+		"proj/drop.log", "proj/keep.log", "proj/src", "proj/src/gen", "proj/src/gen/y.go", "sub"}, indexedPaths(t, fl, root))
+
+	// and so is a removed one, though only the dir it was in changes
+	if err := os.Remove(gitignore); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fl.Index(context.Background(), 2, true); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	equals(t, []string{"", "a.log", "b.txt", "build", "build/out.o", "proj", "proj/.fslocateignore",
+		"proj/.gitignore", "proj/drop.log", "proj/keep.log", "proj/src", "proj/src/gen", "proj/src/gen/y.go",
+		"sub", "sub/build", "sub/build/out.o"}, indexedPaths(t, fl, root))
+}
+
+func TestNestedRoots(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{
+		"a.log":          "",
+		"b/c/d.txt":      "",
+		"mono/a.log":     "",
+		"mono/b/c/d.txt": "",
+	})
+	// the outer root's rules don't apply under the nested root
+	config := `{"roots": [{"path": "` + root + `", "ignore": ["*.log"], "max_depth": 1},
+	                      {"path": "` + filepath.Join(root, "mono") + `/"}]}`
+	writeTree(t, dir, map[string]string{"fslocate.json": config})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		ConfigFile: filepath.Join(dir, "fslocate.json"),
+	}
+	exp := []string{"", "b", "mono", "mono/a.log", "mono/b", "mono/b/c", "mono/b/c/d.txt"}
+	for _, update := range []bool{false, true} {
+		if _, _, err := fl.Index(context.Background(), 2, update); err != nil {
+			t.Fatalf("Index: %v", err)
+		}
+		equals(t, exp, indexedPaths(t, fl, root))
+	}
+}
+
+func TestPruneReport(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{".git/a": "", ".git/b/c": "", "x.class": "", "y.go": ""})
+	global, err := common.NewIgnorePatterns("", "test", []string{".git/", "*.class", "*.o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newPruneReport(logger{})
+	start := startDirs([]*root{newRoot(logger{}, common.RootConfig{Path: dir}, global, nil)})
+	var paths []string
+	for lst := range walk(context.Background(), logger{}, start, nil, report, 2) {
+		paths = append(paths, lst.dir.Path)
+		for _, e := range lst.files {
+			paths = append(paths, e.Path)
+		}
+	}
+	equals(t, []string{dir, filepath.Join(dir, "y.go")}, paths)
+
+	stats := make(map[string]pruneStat)
+	for rule, ps := range report.byRule {
+		stats[rule.Pattern] = pruneStat{dirs: ps.dirs, files: ps.files, entries: ps.entries}
+	}
+	equals(t, map[string]pruneStat{
+		".git/":   {dirs: 1, entries: 3},
+		"*.class": {files: 1},
+	}, stats)
+}
+
+func TestExplain(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{
+		"proj/.gitignore":   "*.o\n!keep.o\n",
+		"proj/a.o":          "",
+		"proj/keep.o":       "",
+		"proj/src/main.go":  "",
+		"deep/1/2/3/x":      "",
+		"vendor/lib/dep.go": "",
+		"target/lib/dep.go": "",
+	})
+	if err := os.Symlink(filepath.Join(root, "proj"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"ignore": ["vendor/"], "roots": [{"path": "` + root + `", "max_depth": 3, "dir_ignore": true}]}`
+	writeTree(t, dir, map[string]string{"fslocate.json": config})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		ConfigFile: filepath.Join(dir, "fslocate.json"),
+	}
+	if _, _, err := fl.Index(context.Background(), 2, false); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		rule     string // the pattern of the rule deciding, if any
+		excluded bool
+		indexed  bool
+	}{
+		{"proj/src/main.go", "", false, true},
+		{"proj/a.o", "*.o", false, false},
+		{"proj/keep.o", "!keep.o", false, true},
+		{"vendor/lib/dep.go", "vendor/", false, false},
+		{"target/lib/dep.go", "", false, true},
+		{"deep/1/2", "", false, true},
+		{"deep/1/2/3", "", true, false},
+		{"link/src/main.go", "", true, false},
+	}
+	for _, tt := range tests {
+		ex, err := fl.Explain(context.Background(), filepath.Join(root, tt.path))
+		if err != nil {
+			t.Fatalf("Explain %s: %v", tt.path, err)
+		}
+		rule := ""
+		if ex.Rule != nil {
+			rule = ex.Rule.Pattern
+		}
+		if rule != tt.rule || (ex.Excluded != "") != tt.excluded || ex.Indexed() != tt.indexed || ex.InDb != tt.indexed {
+			t.Errorf("%s: unexpected explanation: %+v", tt.path, ex)
+		}
+	}
+
+	ex, err := fl.Explain(context.Background(), dir)
+	if err != nil || ex.Root != "" || ex.Indexed() || ex.InDb {
+		t.Errorf("%s: expected no root: %+v, %v", dir, ex, err)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, dir, map[string]string{"root/a/b/file": "", "other/x.txt": ""})
+	for link, target := range map[string]string{
+		"root/a/loop":  "..",                        // a cycle
+		"root/link":    filepath.Join(dir, "other"), // followed, out of the root
+		"root/back":    root,                        // the root itself: a cycle
+		"root/flink":   "a/b/file",                  // not to a dir
+		"root/nowhere": "missing",                   // dangling
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := `{"roots": [{"path": "` + root + `", "follow_symlinks": true}]}`
+	writeTree(t, dir, map[string]string{"fslocate.json": config})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		ConfigFile: filepath.Join(dir, "fslocate.json"),
+	}
+	if _, _, err := fl.Index(context.Background(), 2, false); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+
+	entries := make(map[string]string)
+	_, err := fl.Find(context.Background(), common.Query{Terms: []string{root}}, func(e fsentry.E) {
+		rel := strings.TrimPrefix(strings.TrimPrefix(e.Path, root), "/")
+		entries[rel] = e.Typ + " " + e.Target
+	})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	equals(t, map[string]string{
+		"":           "d ",
+		"a":          "d ",
+		"a/b":        "d ",
+		"a/b/file":   "f ",
+		"a/loop":     "l ..",
+		"back":       "l " + root,
+		"flink":      "l a/b/file",
+		"nowhere":    "l missing",
+		"link":       "d " + filepath.Join(dir, "other"),
+		"link/x.txt": "f ",
+	}, entries)
+
+	// -type l shows followed links as well as the others
+	var links []string
+	_, err = fl.Find(context.Background(), common.Query{Terms: []string{root}, Types: fsentry.SYMLINK}, func(e fsentry.E) {
+		links = append(links, filepath.Base(e.Path))
+	})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	sort.Strings(links)
+	equals(t, []string{"back", "flink", "link", "loop", "nowhere"}, links)
+}
@@ -0,0 +1,232 @@
+package boyer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/quux00/fslocate/common"
+)
+
+//
+// matcher is how Search decides which records match a query. Rather
+// than testing every record in a block, Search asks the matcher where
+// the next candidate is and only tests the record found there.
+//
+type matcher interface {
+	// candidate returns the index in b of the next place that a
+	// match could be, or -1 if there is no match in b
+	candidate(b []byte) int
+
+	// match reports whether the path of a record matches
+	match(path []byte) bool
+}
+
+//
+// newTermMatcher returns the matcher for one search term, matched
+// the way the query says to.
+//
+func newTermMatcher(term string, q common.Query) (matcher, error) {
+	var m matcher
+	var err error
+	switch {
+	case q.Regex && q.Glob:
+		return nil, errors.New("a search term can't be both a regexp and a glob")
+	case q.Regex:
+		m, err = newRegexMatcher(term, q.FoldCase)
+	case q.Glob:
+		m, err = newGlobMatcher(term, q.FoldCase)
+	case q.FoldCase:
+		m = newFoldMatcher(term)
+	default:
+		m = literalMatcher{[]byte(term)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if q.Basename {
+		m = basenameMatcher{m}
+	}
+	return m, nil
+}
+
+//
+// queryMatcher combines the matchers for all the terms in a query.
+// Each record is tested against all of them in the same pass.
+//
+type queryMatcher struct {
+	include []matcher
+	exclude []matcher
+	any     bool
+
+	types string
+	size  *common.SizeFilter
+	newer int64 // Unix nanoseconds; 0 for no filter
+}
+
+func newMatcher(q common.Query) (*queryMatcher, error) {
+	qm := &queryMatcher{any: q.Any, types: q.Types, size: q.Size}
+	if !q.Newer.IsZero() {
+		qm.newer = q.Newer.UnixNano()
+	}
+	for _, term := range q.Terms {
+		m, err := newTermMatcher(term, q)
+		if err != nil {
+			return nil, err
+		}
+		qm.include = append(qm.include, m)
+	}
+	for _, term := range q.Exclude {
+		m, err := newTermMatcher(term, q)
+		if err != nil {
+			return nil, err
+		}
+		qm.exclude = append(qm.exclude, m)
+	}
+	return qm, nil
+}
+
+//
+// inBlock reports whether a block can have any matches in it at all,
+// so that Search can skip over it without looking at its records.
+//
+func (qm *queryMatcher) inBlock(b []byte) bool {
+	for _, m := range qm.include {
+		found := m.candidate(b) >= 0
+		if found && qm.any {
+			return true
+		}
+		if !found && !qm.any {
+			return false
+		}
+	}
+	return len(qm.include) == 0 || !qm.any
+}
+
+func (qm *queryMatcher) candidate(b []byte) int {
+	if len(qm.include) == 1 || (len(qm.include) > 1 && !qm.any) {
+		// a record matching all the terms must match the first one
+		return qm.include[0].candidate(b)
+	}
+	// with only exclusions, or a choice of terms, every record is a candidate
+	if len(b) == 0 {
+		return -1
+	}
+	return 0
+}
+
+//
+// hasFilters reports whether records have to be decoded to check them
+// against the metadata filters
+//
+func (qm *queryMatcher) hasFilters() bool {
+	return qm.types != "" || qm.size != nil || qm.newer != 0
+}
+
+//
+// matchFilters reports whether the metadata fields of a record
+// match the metadata filters
+//
+func (qm *queryMatcher) matchFilters(rec []byte) bool {
+	if !qm.hasFilters() {
+		return true
+	}
+	e, err := decodeRecord(rec)
+	if err != nil {
+		return false
+	}
+	if qm.types != "" && !strings.Contains(qm.types, e.LinkType()) {
+		return false
+	}
+	if qm.size != nil && !qm.size.Match(e.Size) {
+		return false
+	}
+	return qm.newer == 0 || e.Mtime > qm.newer
+}
+
+func (qm *queryMatcher) match(path []byte) bool {
+	for _, m := range qm.exclude {
+		if m.match(path) {
+			return false
+		}
+	}
+	for _, m := range qm.include {
+		found := m.match(path)
+		if found && qm.any {
+			return true
+		}
+		if !found && !qm.any {
+			return false
+		}
+	}
+	return len(qm.include) == 0 || !qm.any
+}
+
+//
+// literalMatcher matches paths containing a literal substring
+//
+type literalMatcher struct {
+	needle []byte
+}
+
+func (m literalMatcher) candidate(b []byte) int {
+	return bytes.Index(b, m.needle)
+}
+
+func (m literalMatcher) match(path []byte) bool {
+	return bytes.Contains(path, m.needle)
+}
+
+//
+// basenameMatcher applies another matcher to just the last element
+// of each path, so that a dir name higher up the path can't match.
+//
+type basenameMatcher struct {
+	matcher
+}
+
+func (m basenameMatcher) match(path []byte) bool {
+	if i := bytes.LastIndexByte(path, os.PathSeparator); i >= 0 {
+		path = path[i+1:]
+	}
+	return m.matcher.match(path)
+}
+
+//
+// regexMatcher matches paths against a regexp. Any literal prefix
+// the regexp has is used to skip over blocks and records that can't
+// match without running the regexp on them.
+//
+type regexMatcher struct {
+	re     *regexp.Regexp
+	prefix []byte
+}
+
+func newRegexMatcher(pattern string, foldCase bool) (matcher, error) {
+	if foldCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := re.LiteralPrefix()
+	return regexMatcher{re: re, prefix: []byte(prefix)}, nil
+}
+
+func (m regexMatcher) candidate(b []byte) int {
+	if len(m.prefix) == 0 {
+		// no prefix to look for, so every record is a candidate
+		if len(b) == 0 {
+			return -1
+		}
+		return 0
+	}
+	return bytes.Index(b, m.prefix)
+}
+
+func (m regexMatcher) match(path []byte) bool {
+	return m.re.Match(path)
+}
@@ -0,0 +1,280 @@
+package boyer
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+var testEntries = []fsentry.E{
+	{Path: "/usr/local/go", Typ: fsentry.DIR, Size: 4096, Mtime: 1381234567000000000, Mode: 0755},
+	{Path: "/usr/local/go/README.md", Typ: fsentry.FILE, Size: 1210, Mtime: 1381234567000000000, Mode: 0644},
+	{Path: "/usr/local/go/api/go1.txt", Typ: fsentry.FILE, Size: 3 << 20, Mtime: 1700000000000000000, Mode: 0644},
+	{Path: "/home/quux00/golang", Typ: fsentry.DIR, Size: 4096, Mtime: 1381234567000000001, Mode: 0700},
+	{Path: "/home/quux00/golang/main.go", Typ: fsentry.FILE, Size: 200, Mtime: 1800000000000000000, Mode: 0600},
+	{Path: "/home/quux00/notes/todo.txt", Typ: fsentry.SYMLINK, Size: 20, Mtime: 1381234567000000000, Mode: 0777, Target: "../todo.txt"},
+}
+
+func testBlock() []byte {
+	var b bytes.Buffer
+	for _, e := range testEntries {
+		b.WriteString(encodeRecord(e))
+		b.WriteByte(RECORD_SEP)
+	}
+	return b.Bytes()
+}
+
+func searchTestBlock(t *testing.T, q common.Query) []string {
+	m, err := newMatcher(q)
+	if err != nil {
+		t.Fatalf("newMatcher: %v", err)
+	}
+	var found []string
+	searchBlock(testBlock(), m, func(path, rec []byte) bool {
+		found = append(found, string(path))
+		return true
+	})
+	return found
+}
+
+func TestSearchLiteral(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"golang"}})
+	equals(t, []string{"/home/quux00/golang", "/home/quux00/golang/main.go"}, found)
+
+	// metadata fields are not part of the path
+	found = searchTestBlock(t, common.Query{Terms: []string{"1381234567"}})
+	if len(found) != 0 {
+		t.Errorf("%v", found)
+	}
+}
+
+func TestSearchRegex(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{`\.txt$`}, Regex: true})
+	equals(t, []string{"/usr/local/go/api/go1.txt", "/home/quux00/notes/todo.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{`^/usr/local/go/[A-Z]`}, Regex: true})
+	equals(t, []string{"/usr/local/go/README.md"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{`go$`}, Regex: true})
+	equals(t, []string{"/usr/local/go", "/home/quux00/golang/main.go"}, found)
+}
+
+func TestRegexPrefix(t *testing.T) {
+	m, err := newRegexMatcher(`/home/quux00/.*\.go`, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(m.(regexMatcher).prefix) != "/home/quux00/" {
+		t.Errorf("prefix: %q", m.(regexMatcher).prefix)
+	}
+	if m.candidate([]byte("/usr/local/go/main.go")) != -1 {
+		t.Errorf("block without prefix is a candidate")
+	}
+
+	if _, err = newMatcher(common.Query{Terms: []string{`a(b`}, Regex: true}); err == nil {
+		t.Errorf("expected error for bad regexp")
+	}
+}
+
+func TestSearchGlob(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"*.txt"}, Glob: true})
+	equals(t, []string{"/usr/local/go/api/go1.txt", "/home/quux00/notes/todo.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"/usr/**/*.txt"}, Glob: true})
+	equals(t, []string{"/usr/local/go/api/go1.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"**/go/*"}, Glob: true})
+	equals(t, []string{"/usr/local/go/README.md"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"go?.[a-z]xt"}, Glob: true})
+	equals(t, []string{"/usr/local/go/api/go1.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"/home/*/golang"}, Glob: true})
+	equals(t, []string{"/home/quux00/golang"}, found)
+}
+
+func TestSearchBasename(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"go"}, Basename: true})
+	equals(t, []string{"/usr/local/go", "/usr/local/go/api/go1.txt", "/home/quux00/golang",
+		"/home/quux00/golang/main.go"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"go*"}, Glob: true, Basename: true})
+	equals(t, []string{"/usr/local/go", "/usr/local/go/api/go1.txt", "/home/quux00/golang"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"^[a-z]+\\.txt$"}, Regex: true, Basename: true})
+	equals(t, []string{"/home/quux00/notes/todo.txt"}, found)
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob, expr, literal string
+	}{
+		{"*.go", `^(?:.*/)?[^/]*\.go$`, ".go"},
+		{"**/testdata/*.json", `^(?:.*/)?testdata/[^/]*\.json$`, "testdata/"},
+		{"report-202?-*.pdf", `^(?:.*/)?report-202[^/]-[^/]*\.pdf$`, "report-202"},
+		{"/usr/**", `^/usr/.*$`, "/usr/"},
+		{"/a/**/b", `^/a/(?:.*/)?b$`, "/a/"},
+		{"a**b", `^(?:.*/)?a[^/]*b$`, "a"},
+		{"[!a-c]x", `^(?:.*/)?[^a-c]x$`, "x"},
+		{`\*x`, `^(?:.*/)?\*x$`, "*x"},
+	}
+	for _, tt := range tests {
+		expr, literal, err := globToRegexp(tt.glob)
+		if err != nil {
+			t.Errorf("%s: %v", tt.glob, err)
+		}
+		if expr != tt.expr {
+			t.Errorf("%s: expr %s", tt.glob, expr)
+		}
+		if literal != tt.literal {
+			t.Errorf("%s: literal %q", tt.glob, literal)
+		}
+	}
+
+	if _, _, err := globToRegexp("[abc"); err == nil {
+		t.Errorf("expected error for unterminated '['")
+	}
+}
+
+func TestSearchFoldCase(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"readme"}, FoldCase: true})
+	equals(t, []string{"/usr/local/go/README.md"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"readme"}})
+	if len(found) != 0 {
+		t.Errorf("%v", found)
+	}
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"TODO.*"}, Glob: true, FoldCase: true})
+	equals(t, []string{"/home/quux00/notes/todo.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"/NOTES/"}, Regex: true, FoldCase: true})
+	equals(t, []string{"/home/quux00/notes/todo.txt"}, found)
+}
+
+func TestFoldMatcherUnicode(t *testing.T) {
+	tests := []struct {
+		needle, path string
+		exp          bool
+	}{
+		{"σας", "/docs/ΣΑΣ.txt", true},
+		{"ΣΑΣ", "/docs/σας.txt", true},
+		{"kelvin", "/docs/KELVIN", true}, // Kelvin sign
+		{"s.go", "/src/ſ.go", true},      // long s
+		{"é", "/café/É", true},
+		{"straße", "/STRASSE", false},
+		{"abc", "/ab", false},
+		{"", "/anything", true},
+	}
+	for _, tt := range tests {
+		m := newFoldMatcher(tt.needle)
+		if m.match([]byte(tt.path)) != tt.exp {
+			t.Errorf("%q in %q: expected %v", tt.needle, tt.path, tt.exp)
+		}
+	}
+}
+
+func TestSearchAllTerms(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"go", ".txt"}})
+	equals(t, []string{"/usr/local/go/api/go1.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"go", "quux00", ".md"}})
+	if len(found) != 0 {
+		t.Errorf("%v", found)
+	}
+}
+
+func TestSearchAnyTerm(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"README", "todo"}, Any: true})
+	equals(t, []string{"/usr/local/go/README.md", "/home/quux00/notes/todo.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"nothere", "neither"}, Any: true})
+	if len(found) != 0 {
+		t.Errorf("%v", found)
+	}
+}
+
+func TestSearchExclude(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"go"}, Exclude: []string{"golang", "api"}})
+	equals(t, []string{"/usr/local/go", "/usr/local/go/README.md"}, found)
+
+	// exclusions only
+	found = searchTestBlock(t, common.Query{Exclude: []string{"/usr/"}})
+	equals(t, []string{"/home/quux00/golang", "/home/quux00/golang/main.go", "/home/quux00/notes/todo.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"*.txt"}, Exclude: []string{"**/notes/*"}, Glob: true})
+	equals(t, []string{"/usr/local/go/api/go1.txt"}, found)
+}
+
+func TestSearchBlockStop(t *testing.T) {
+	m, _ := newMatcher(common.Query{Terms: []string{"/"}})
+	n := 0
+	more := searchBlock(testBlock(), m, func(path, rec []byte) bool {
+		n++
+		return n < 2
+	})
+	if more || n != 2 {
+		t.Errorf("more: %v; n: %d", more, n)
+	}
+}
+
+func TestResultWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+
+	dir := encodeRecord(testEntries[0])
+	file := encodeRecord(fsentry.E{Path: "/usr/local/go/a\nb", Typ: fsentry.FILE, Size: 12, Mtime: UNKNOWN_MTIME, Mode: 0640})
+	// not valid UTF-8, as Unix file names can be
+	latin1 := encodeRecord(fsentry.E{Path: "/usr/local/go/caf\xe9", Typ: fsentry.SYMLINK, Mtime: UNKNOWN_MTIME,
+		Mode: 0777, Target: "\xe9t\xe9"})
+	for _, format := range []common.OutputFormat{common.FORMAT_LINES, common.FORMAT_NUL, common.FORMAT_JSON} {
+		write := newResultWriter(w, format)
+		write(recordPath([]byte(dir)), []byte(dir))
+		write(recordPath([]byte(file)), []byte(file))
+		write(recordPath([]byte(latin1)), []byte(latin1))
+	}
+	w.Flush()
+
+	exp := "/usr/local/go\n/usr/local/go/a\nb\n/usr/local/go/caf\xe9\n" +
+		"/usr/local/go\x00/usr/local/go/a\nb\x00/usr/local/go/caf\xe9\x00" +
+		`{"path":"/usr/local/go","type":"d","size":4096,"mtime":"` +
+		time.Unix(0, 1381234567000000000).Format(time.RFC3339Nano) + `","mode":"0755"}` + "\n" +
+		`{"path":"/usr/local/go/a\nb","type":"f","size":12,"mode":"0640"}` + "\n" +
+		"{\"path\":\"/usr/local/go/caf\ufffd\",\"path_b64\":\"L3Vzci9sb2NhbC9nby9jYWbp\",\"type\":\"l\",\"size\":0," +
+		"\"mode\":\"0777\",\"target\":\"\ufffdt\ufffd\",\"target_b64\":\"6XTp\"}\n"
+	equals(t, exp, out.String())
+}
+
+func TestSearchFilters(t *testing.T) {
+	found := searchTestBlock(t, common.Query{Terms: []string{"go"}, Types: fsentry.DIR})
+	equals(t, []string{"/usr/local/go", "/home/quux00/golang"}, found)
+
+	found = searchTestBlock(t, common.Query{Types: fsentry.SYMLINK + fsentry.FILE, Exclude: []string{"/usr/"}})
+	equals(t, []string{"/home/quux00/golang/main.go", "/home/quux00/notes/todo.txt"}, found)
+
+	size, _ := common.ParseSizeFilter("+1M")
+	found = searchTestBlock(t, common.Query{Size: size})
+	equals(t, []string{"/usr/local/go/api/go1.txt"}, found)
+
+	found = searchTestBlock(t, common.Query{Terms: []string{"go"}, Newer: time.Unix(0, 1600000000000000000)})
+	equals(t, []string{"/usr/local/go/api/go1.txt", "/home/quux00/golang/main.go"}, found)
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	followed := fsentry.E{Path: "/home/quux00/src", Typ: fsentry.DIR, Size: 4096, Mtime: 1, Mode: 0755, Target: "/srv/src"}
+	for _, e := range append(testEntries, followed) {
+		act, err := decodeRecord([]byte(encodeRecord(e)))
+		if err != nil {
+			t.Errorf("%v: %v", e, err)
+		}
+		equals(t, e, act)
+	}
+
+	if _, err := decodeRecord([]byte("/just/a/path")); err != ErrBadRecord {
+		t.Errorf("err: %v", err)
+	}
+}
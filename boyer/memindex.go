@@ -0,0 +1,152 @@
+package boyer
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// memIndex is an in-memory copy of the db, kept up to date by the
+// watch daemon and written out as a new db at each checkpoint.
+// It is not safe for concurrent use.
+//
+type memIndex struct {
+	dirs     map[string]*memDir
+	children map[string]map[string]bool // dir => the paths of its subdirs in dirs
+	dirty    bool                       // changed since the last checkpoint
+}
+
+type memDir struct {
+	entry fsentry.E
+	files map[string]fsentry.E // keyed by path
+}
+
+func newMemIndex() *memIndex {
+	return &memIndex{dirs: make(map[string]*memDir), children: make(map[string]map[string]bool)}
+}
+
+//
+// putDir adds d at path, linking it to its parent dir's children so
+// that removing a dir only has to visit the dirs under it, not all of
+// them. The parent doesn't have to be in the index yet: the walk can
+// send a dir's listing before its parent's.
+//
+func (mi *memIndex) putDir(path string, d *memDir) {
+	mi.dirs[path] = d
+	parent := filepath.Dir(path)
+	if parent == path {
+		return
+	}
+	subs, ok := mi.children[parent]
+	if !ok {
+		subs = make(map[string]bool)
+		mi.children[parent] = subs
+	}
+	subs[path] = true
+}
+
+// removeDir takes the dir at path and all the dirs under it out of the index
+func (mi *memIndex) removeDir(path string, removed []string) []string {
+	removed = append(removed, path)
+	delete(mi.dirs, path)
+	for sub := range mi.children[path] {
+		removed = mi.removeDir(sub, removed)
+	}
+	delete(mi.children, path)
+	if subs, ok := mi.children[filepath.Dir(path)]; ok {
+		delete(subs, path)
+		if len(subs) == 0 {
+			delete(mi.children, filepath.Dir(path))
+		}
+	}
+	return removed
+}
+
+// addListing adds (or replaces) a dir and the files in it
+func (mi *memIndex) addListing(lst dirListing) {
+	if lst.missing {
+		return
+	}
+	d := &memDir{entry: lst.dir, files: make(map[string]fsentry.E, len(lst.files))}
+	for _, e := range lst.files {
+		d.files[e.Path] = e
+	}
+	mi.putDir(lst.dir.Path, d)
+	mi.dirty = true
+}
+
+//
+// set adds or updates an entry. A dir that isn't in the index yet is
+// added with no files; a file is only added if its dir is in the index.
+//
+func (mi *memIndex) set(e fsentry.E) {
+	if e.Typ == fsentry.DIR {
+		if d, ok := mi.dirs[e.Path]; ok {
+			d.entry = e
+		} else {
+			mi.putDir(e.Path, &memDir{entry: e, files: make(map[string]fsentry.E)})
+		}
+		mi.dirty = true
+		return
+	}
+	if d, ok := mi.dirs[filepath.Dir(e.Path)]; ok {
+		d.files[e.Path] = e
+		mi.dirty = true
+	}
+}
+
+//
+// remove takes a path out of the index. If it is a dir, everything
+// under it goes too. Returns the paths of the dirs that were removed.
+//
+func (mi *memIndex) remove(path string) []string {
+	if d, ok := mi.dirs[filepath.Dir(path)]; ok {
+		if _, ok = d.files[path]; ok {
+			delete(d.files, path)
+			mi.dirty = true
+		}
+	}
+	if _, ok := mi.dirs[path]; !ok {
+		return nil
+	}
+
+	removed := mi.removeDir(path, nil)
+	mi.dirty = true
+	return removed
+}
+
+//
+// listings returns a snapshot of the index as dir listings, sorted
+// by path, ready to be written out with writeDb
+//
+func (mi *memIndex) listings() []dirListing {
+	paths := make([]string, 0, len(mi.dirs))
+	for dir := range mi.dirs {
+		paths = append(paths, dir)
+	}
+	sort.Strings(paths)
+
+	lsts := make([]dirListing, 0, len(paths))
+	for _, dir := range paths {
+		d := mi.dirs[dir]
+		lst := dirListing{dir: d.entry, files: make([]fsentry.E, 0, len(d.files))}
+		for _, e := range d.files {
+			lst.files = append(lst.files, e)
+		}
+		sort.Slice(lst.files, func(i, j int) bool { return lst.files[i].Path < lst.files[j].Path })
+		lsts = append(lsts, lst)
+	}
+	return lsts
+}
+
+// feedListings returns a closed channel holding lsts
+func feedListings(lsts []dirListing) <-chan dirListing {
+	ch := make(chan dirListing, len(lsts))
+	for _, lst := range lsts {
+		ch <- lst
+	}
+	close(ch)
+	return ch
+}
@@ -0,0 +1,53 @@
+package boyer
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/quux00/fslocate/fsentry"
+)
+
+func memIndexPaths(mi *memIndex) []string {
+	var paths []string
+	for _, lst := range mi.listings() {
+		paths = append(paths, lst.dir.Path)
+		for _, e := range lst.files {
+			paths = append(paths, e.Path)
+		}
+	}
+	return paths
+}
+
+func TestMemIndex(t *testing.T) {
+	mi := newMemIndex()
+	mi.addListing(dirListing{
+		dir:   fsentry.E{Path: "/a", Typ: fsentry.DIR},
+		files: []fsentry.E{{Path: "/a/z.txt", Typ: fsentry.FILE}, {Path: "/a/b.txt", Typ: fsentry.FILE}},
+	})
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/a/sub", Typ: fsentry.DIR}})
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/a/sub/deeper", Typ: fsentry.DIR}})
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/a/subway", Typ: fsentry.DIR}})
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/gone", Typ: fsentry.DIR}, missing: true})
+
+	mi.set(fsentry.E{Path: "/a/sub/new.go", Typ: fsentry.FILE})
+	mi.set(fsentry.E{Path: "/nodir/new.go", Typ: fsentry.FILE})
+	equals(t, []string{"/a", "/a/b.txt", "/a/z.txt", "/a/sub", "/a/sub/new.go",
+		"/a/sub/deeper", "/a/subway"}, memIndexPaths(mi))
+
+	mi.dirty = false
+	equals(t, []string{"/a/sub", "/a/sub/deeper"}, mi.remove("/a/sub"))
+	equals(t, []string(nil), mi.remove("/a/z.txt"))
+	equals(t, true, mi.dirty)
+	equals(t, []string{"/a", "/a/b.txt", "/a/subway"}, memIndexPaths(mi))
+	equals(t, map[string]map[string]bool{"/": {"/a": true}, "/a": {"/a/subway": true}}, mi.children)
+
+	// a dir's listing can come before its parent's
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/b/c/d", Typ: fsentry.DIR}})
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/b/c", Typ: fsentry.DIR}})
+	mi.addListing(dirListing{dir: fsentry.E{Path: "/b/e", Typ: fsentry.DIR}})
+	mi.set(fsentry.E{Path: "/b", Typ: fsentry.DIR})
+	removed := mi.remove("/b")
+	sort.Strings(removed)
+	equals(t, []string{"/b", "/b/c", "/b/c/d", "/b/e"}, removed)
+	equals(t, []string{"/a", "/a/b.txt", "/a/subway"}, memIndexPaths(mi))
+}
@@ -0,0 +1,84 @@
+package boyer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/quux00/fslocate/common"
+)
+
+//
+// jsonResult is what a match looks like in common.FORMAT_JSON.
+// Mtime is left out for dirs that could not be read when indexing.
+// JSON strings can't hold bytes that aren't valid UTF-8, which Unix
+// paths can have, and encoding/json would turn them into U+FFFD. So
+// for a path (or target) that isn't valid UTF-8, the exact bytes are
+// also given in PathB64 (or TargetB64), base64 encoded.
+//
+type jsonResult struct {
+	Path      string     `json:"path"`
+	PathB64   []byte     `json:"path_b64,omitempty"`
+	Type      string     `json:"type"`
+	Size      int64      `json:"size"`
+	Mtime     *time.Time `json:"mtime,omitempty"`
+	Mode      string     `json:"mode"`             // permission bits in octal
+	Target    string     `json:"target,omitempty"` // for a symlink
+	TargetB64 []byte     `json:"target_b64,omitempty"`
+}
+
+//
+// newResultWriter returns a func that writes one matching record
+// to w in the given format
+//
+func newResultWriter(w *bufio.Writer, format common.OutputFormat) func(path, rec []byte) {
+	switch format {
+	case common.FORMAT_NUL:
+		return func(path, rec []byte) {
+			w.Write(path)
+			w.WriteByte(0)
+		}
+	case common.FORMAT_JSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return func(path, rec []byte) {
+			enc.Encode(newJsonResult(path, rec))
+		}
+	default:
+		return func(path, rec []byte) {
+			w.Write(path)
+			w.WriteByte('\n')
+		}
+	}
+}
+
+func newJsonResult(path, rec []byte) jsonResult {
+	e, err := decodeRecord(rec)
+	if err != nil {
+		return jsonResult{Path: string(path), PathB64: rawBytes(string(path))}
+	}
+	res := jsonResult{
+		Path:      e.Path,
+		PathB64:   rawBytes(e.Path),
+		Type:      e.Typ,
+		Size:      e.Size,
+		Mode:      fmt.Sprintf("%04o", uint32(e.Mode)),
+		Target:    e.Target,
+		TargetB64: rawBytes(e.Target),
+	}
+	if e.Mtime != UNKNOWN_MTIME {
+		t := time.Unix(0, e.Mtime)
+		res.Mtime = &t
+	}
+	return res
+}
+
+// rawBytes returns the bytes of s if it isn't valid UTF-8, or nil if it is
+func rawBytes(s string) []byte {
+	if utf8.ValidString(s) {
+		return nil
+	}
+	return []byte(s)
+}
@@ -0,0 +1,99 @@
+package boyer
+
+import (
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/quux00/fslocate/common"
+)
+
+//
+// pruneReport records what each ignore rule left out of an index run:
+// the dirs it pruned, with the number of entries under them, and the
+// files it ignored. It is only kept in verbose mode, as counting the
+// entries under a pruned dir means reading it after all.
+//
+type pruneReport struct {
+	log    logger
+	mu     sync.Mutex
+	byRule map[*common.IgnoreRule]*pruneStat
+}
+
+type pruneStat struct {
+	rule    *common.IgnoreRule
+	dirs    int   // dirs pruned
+	files   int   // files ignored
+	entries int64 // entries under the pruned dirs
+}
+
+// saved is the number of entries the rule kept out of the db
+func (ps *pruneStat) saved() int64 {
+	return int64(ps.dirs+ps.files) + ps.entries
+}
+
+func newPruneReport(log logger) *pruneReport {
+	return &pruneReport{log: log, byRule: make(map[*common.IgnoreRule]*pruneStat)}
+}
+
+//
+// add records that rule ignored path. For a dir, the entries under it
+// are counted. Does nothing if rp is nil.
+//
+func (rp *pruneReport) add(rule *common.IgnoreRule, path string, isDir bool) {
+	if rp == nil {
+		return
+	}
+	var n int64
+	if isDir {
+		n = countEntries(path)
+		rp.log.prf("Pruned dir: %s (%d entries) by %s\n", path, n, rule)
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	ps, ok := rp.byRule[rule]
+	if !ok {
+		ps = &pruneStat{rule: rule}
+		rp.byRule[rule] = ps
+	}
+	if isDir {
+		ps.dirs++
+		ps.entries += n
+	} else {
+		ps.files++
+	}
+}
+
+// print writes out how much each rule saved, most first
+func (rp *pruneReport) print() {
+	if rp == nil || len(rp.byRule) == 0 {
+		return
+	}
+	stats := make([]*pruneStat, 0, len(rp.byRule))
+	for _, ps := range rp.byRule {
+		stats = append(stats, ps)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].saved() > stats[j].saved()
+	})
+	rp.log.prn("Pruned by ignore rules:")
+	for _, ps := range stats {
+		rp.log.prf("  %-40s %d dirs, %d files, %d entries saved\n", ps.rule, ps.dirs, ps.files, ps.saved())
+	}
+}
+
+// countEntries returns the number of entries under dir, not following symlinks
+func countEntries(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	n := int64(len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			n += countEntries(common.CreateFullPath(dir, e.Name()))
+		}
+	}
+	return n
+}
@@ -0,0 +1,144 @@
+package boyer
+
+import (
+	"sync"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// dirListing is the unit of work handed from an indexer goroutine
+// to the writer: a directory and the (non-dir) entries directly in
+// it. If the dir could not be read, err is set, and missing is set
+// if the dir could not even be stat'd (or can't be stored in the db).
+// skipped is why any of its entries were left out. ignores is the
+// per-dir ignore rules that applied to the entries, and linked is set
+// if the dir was reached through a followed symlink.
+//
+type dirListing struct {
+	dir     fsentry.E
+	files   []fsentry.E
+	err     error
+	missing bool
+	skipped []error
+	ignores *ignoreStack
+	linked  bool
+}
+
+//
+// queuedDir is a dir waiting to be read, along with the root it is
+// under, how many levels below the root it is and the rules from the
+// per-dir ignore files above it. When following symlinks, it also has
+// the dirs above it, to spot cycles, and whether it is (link) or is
+// under (linked) a followed symlink.
+//
+type queuedDir struct {
+	path    string
+	root    *root
+	depth   int
+	ignores *ignoreStack
+	chain   *dirChain
+	link    bool
+	linked  bool
+}
+
+// child returns the queuedDir for a subdir of d
+func (d queuedDir) child(path string) queuedDir {
+	return queuedDir{
+		path:    path,
+		root:    d.root,
+		depth:   d.depth + 1,
+		ignores: d.ignores,
+		chain:   d.chain,
+		linked:  d.linked,
+	}
+}
+
+//
+// ignoredBy returns the rule that says path, in d, should not be
+// indexed, or nil if it should be. The rules in the per-dir ignore
+// files come before those for the root.
+//
+func (d queuedDir) ignoredBy(path string, isDir bool) *common.IgnoreRule {
+	rule := d.ignores.match(path, isDir)
+	if rule == nil {
+		rule = d.root.match(path, isDir)
+	}
+	if rule == nil || rule.Negate {
+		return nil
+	}
+	return rule
+}
+
+//
+// dirQueue is an unbounded FIFO of directories waiting to be read,
+// shared by all the indexer goroutines. It keeps a count of dirs that
+// are either queued or still being processed, so that an idle indexer
+// can tell the difference between "nothing to do yet" and "walk done".
+//
+type dirQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	dirs      []queuedDir
+	pending   int
+	cancelled bool
+}
+
+func newDirQueue(dirs []queuedDir) *dirQueue {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	for _, d := range dirs {
+		q.dirs = append(q.dirs, d)
+		q.pending++
+	}
+	return q
+}
+
+// push adds a dir to the back of the queue. It must be called by
+// an indexer before it calls done on the parent dir.
+func (q *dirQueue) push(dir queuedDir) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+//
+// pop blocks until a dir is available and returns it. Returns false
+// once the queue is empty and no dirs are left in progress, meaning
+// no more work will ever arrive, or once the queue is cancelled.
+//
+func (q *dirQueue) pop() (queuedDir, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.dirs) == 0 && q.pending > 0 && !q.cancelled {
+		q.cond.Wait()
+	}
+	if len(q.dirs) == 0 || q.cancelled {
+		return queuedDir{}, false
+	}
+	dir := q.dirs[0]
+	q.dirs = q.dirs[1:]
+	return dir, true
+}
+
+// done marks a dir returned by pop as fully processed.
+func (q *dirQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	finished := q.pending == 0
+	q.mu.Unlock()
+	if finished {
+		q.cond.Broadcast()
+	}
+}
+
+// cancel makes every pop, waiting or not, return false from now on.
+func (q *dirQueue) cancel() {
+	q.mu.Lock()
+	q.cancelled = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
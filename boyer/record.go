@@ -0,0 +1,178 @@
+package boyer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// A record in the boyer db is a path followed by the metadata fields
+// for the entry, each preceded by a FIELD_SEP, and terminated by
+// RECORD_SEP:
+//   path
+//   type (one of the fsentry type letters)
+//   size in bytes (decimal)
+//   mtime in Unix nanoseconds (decimal)
+//   permission bits (octal)
+//   symlink target, only for symlinks (format version 3 on)
+//
+// A symlink to a dir that was followed is written as a dir with the
+// target of the link. The files in a directory are always written right after the
+// directory's record. Dir mtimes let an incremental index tell which
+// dirs have changed since the db was written.
+//
+const (
+	FIELD_SEP     = 0x1f // "Unit Separator" char in ASCII
+	NUM_FIELDS    = 5    // including the path, not including the optional target
+	UNKNOWN_MTIME = -1   // for dirs that could not be read
+
+	SEPARATORS = string(rune(FIELD_SEP)) + string(rune(RECORD_SEP))
+)
+
+var ErrBadRecord = errors.New("bad record in db")
+
+func encodeRecord(e fsentry.E) string {
+	var buf bytes.Buffer
+	buf.WriteString(e.Path)
+	buf.WriteByte(FIELD_SEP)
+	buf.WriteString(e.Typ)
+	buf.WriteByte(FIELD_SEP)
+	buf.WriteString(strconv.FormatInt(e.Size, 10))
+	buf.WriteByte(FIELD_SEP)
+	buf.WriteString(strconv.FormatInt(e.Mtime, 10))
+	buf.WriteByte(FIELD_SEP)
+	buf.WriteString(strconv.FormatUint(uint64(e.Mode), 8))
+	if e.Target != "" {
+		buf.WriteByte(FIELD_SEP)
+		buf.WriteString(e.Target)
+	}
+	return buf.String()
+}
+
+func decodeRecord(rec []byte) (fsentry.E, error) {
+	fields := bytes.Split(rec, []byte{FIELD_SEP})
+	if len(fields) != NUM_FIELDS && len(fields) != NUM_FIELDS+1 {
+		return fsentry.E{}, ErrBadRecord
+	}
+	size, err1 := strconv.ParseInt(string(fields[2]), 10, 64)
+	mtime, err2 := strconv.ParseInt(string(fields[3]), 10, 64)
+	mode, err3 := strconv.ParseUint(string(fields[4]), 8, 32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return fsentry.E{}, ErrBadRecord
+	}
+	e := fsentry.E{
+		Path:  string(fields[0]),
+		Typ:   string(fields[1]),
+		Size:  size,
+		Mtime: mtime,
+		Mode:  os.FileMode(mode),
+	}
+	if len(fields) > NUM_FIELDS {
+		e.Target = string(fields[NUM_FIELDS])
+	}
+	return e, nil
+}
+
+//
+// checkStorable returns an error if e can't be stored in the db: its
+// path or target has a FIELD_SEP or RECORD_SEP in it. Unix file names
+// can have any byte other than / and NUL, but those two would split
+// the record, so such entries are left out of the db and reported as
+// skipped instead.
+//
+func checkStorable(e fsentry.E) error {
+	if strings.ContainsAny(e.Path, SEPARATORS) || strings.ContainsAny(e.Target, SEPARATORS) {
+		return fmt.Errorf("%q: has a 0x1e or 0x1f byte, which the db can't store", e.Path)
+	}
+	return nil
+}
+
+// recordPath returns the path portion of a record, without any fields
+func recordPath(rec []byte) []byte {
+	if i := bytes.IndexByte(rec, FIELD_SEP); i >= 0 {
+		return rec[:i]
+	}
+	return rec
+}
+
+//
+// prevDir is what a previous db run knows about one directory:
+// its mtime at the time and the entries that were in it.
+//
+type prevDir struct {
+	mtime   int64
+	files   []fsentry.E
+	subdirs []string
+}
+
+//
+// readPrevDb reads a boyer db written by a previous index run and
+// returns its directories keyed by path, along with its header info.
+// Returns nils if the db does not exist.
+//
+func readPrevDb(fpath string) (map[string]*prevDir, *DbInfo, error) {
+	file, info, err := openDb(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	dirs := make(map[string]*prevDir)
+	var cur *prevDir
+	br := newBlockReader(file)
+	for {
+		payload, nrec, err := br.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var recErr error
+		err = eachRecord(payload, nrec, func(rec []byte) {
+			e, err := decodeRecord(rec)
+			if err != nil {
+				recErr = err
+				return
+			}
+			cur = addPrevEntry(dirs, cur, e)
+		})
+		if err == nil {
+			err = recErr
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// dirs can be written before their parent, so link them up at the end
+	for dir := range dirs {
+		if parent, ok := dirs[filepath.Dir(dir)]; ok && parent != dirs[dir] {
+			parent.subdirs = append(parent.subdirs, dir)
+		}
+	}
+	return dirs, info, nil
+}
+
+func addPrevEntry(dirs map[string]*prevDir, cur *prevDir, e fsentry.E) *prevDir {
+	if e.Typ != fsentry.DIR {
+		if cur != nil {
+			cur.files = append(cur.files, e)
+		}
+		return cur
+	}
+	d := &prevDir{mtime: e.Mtime}
+	dirs[e.Path] = d
+	return d
+}
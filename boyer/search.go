@@ -7,48 +7,138 @@
 package boyer
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"log"
-	"os"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
 )
 
-func (_ BoyerFsLocate) Search(s string) {
+//
+// Search writes every entry in the db matching q to out in the
+// format q.Format asks for, stopping after q.Limit matches if it
+// is set. Returns the number of matches. If a query server is
+// running (see Serve), the search is done by the server.
+//
+func (fl BoyerFsLocate) Search(ctx context.Context, q common.Query, out io.Writer) (int, error) {
+	fl = fl.withDefaults()
+	if nfound, ok, err := searchServer(ctx, fl.log(), socketFile(fl.DbFile), q, out); ok {
+		return nfound, err
+	}
 
-	file, err := os.Open(OUT_FILE)
+	w := bufio.NewWriter(out)
+	nfound, err := fl.searchDb(ctx, q, newResultWriter(w, q.Format))
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return nfound, err
+}
+
+//
+// Find calls found with every entry in the db matching q, stopping
+// after q.Limit matches if it is set. q.Format is ignored. Returns
+// the number of matches.
+//
+func (fl BoyerFsLocate) Find(ctx context.Context, q common.Query, found func(e fsentry.E)) (int, error) {
+	fl = fl.withDefaults()
+	var err error
+	nfound, serr := fl.searchDb(ctx, q, func(path, rec []byte) {
+		var e fsentry.E
+		if e, err = decodeRecord(rec); err == nil {
+			found(e)
+		}
+	})
+	if serr != nil {
+		return nfound, serr
+	}
+	return nfound, err
+}
+
+// searchDb searches the db file itself, calling found with every match
+func (fl BoyerFsLocate) searchDb(ctx context.Context, q common.Query, found func(path, rec []byte)) (int, error) {
+	m, err := newMatcher(q)
 	if err != nil {
-		log.Fatalf("ERROR 1: %v\n", err)
+		return 0, fmt.Errorf("Invalid search term: %v", err)
+	}
+
+	file, _, err := openDb(fl.DbFile)
+	if err != nil {
+		return 0, err
 	}
 	defer file.Close()
 
-	needle := []byte(s)
-	var rb []byte
-	b := make([]byte, BUFSZ)
+	br := newBlockReader(file)
+	next := func() ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rb, _, err := br.next()
+		if err != nil && err != io.EOF {
+			err = fmt.Errorf("%s: %v", fl.DbFile, err)
+		}
+		return rb, err
+	}
+	return searchBlocks(next, m, q.Limit, found)
+}
+
+//
+// searchBlocks searches each block payload returned by next until it
+// returns io.EOF, calling found with every match, stopping after limit
+// matches if it is > 0. Returns the number of matches and the first
+// error other than io.EOF from next.
+//
+func searchBlocks(next func() ([]byte, error), m *queryMatcher, limit int,
+	found func(path, rec []byte)) (int, error) {
 
+	nfound := 0
 	for {
-		n, err := file.Read(b)
+		rb, err := next()
 		if err != nil {
 			if err == io.EOF {
-				break
+				return nfound, nil
 			}
-			log.Fatalf("ERROR 2: %v\n", err)
+			return nfound, err
 		}
-		if n <= 0 {
-			break
+		more := searchBlock(rb, m, func(path, rec []byte) bool {
+			found(path, rec)
+			nfound++
+			return limit <= 0 || nfound < limit
+		})
+		if !more {
+			return nfound, nil
 		}
-		rb = b[0:n]
+	}
+}
 
-		for {
-			n = bytes.Index(rb, needle)
-			if n < 0 {
-				break
+//
+// searchBlock calls found with the path and full record of every
+// record in the block payload rb that m matches, until found returns
+// false. Returns false if it was stopped by found.
+//
+func searchBlock(rb []byte, m *queryMatcher, found func(path, rec []byte) bool) bool {
+	if !m.inBlock(rb) {
+		return true
+	}
+	for {
+		n := m.candidate(rb)
+		if n < 0 {
+			break
+		}
+		entry, endpos := extractEntry(rb, n)
+		// the candidate may have been in the metadata fields
+		if path := recordPath(entry); m.match(path) && m.matchFilters(entry) {
+			if !found(path, entry) {
+				return false
 			}
-			entry, endpos := extractEntry(rb, n)
-			fmt.Println(string(entry))
-			rb = rb[endpos+1:]
 		}
+		if endpos >= len(rb) {
+			break
+		}
+		rb = rb[endpos+1:]
 	}
+	return true
 }
 
 //
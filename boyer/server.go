@@ -0,0 +1,367 @@
+package boyer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/quux00/fslocate/common"
+)
+
+const (
+	SOCKET_EXT = ".sock" // the socket is the db file name with this added
+
+	FRAME_HEADER_SZ = 4                // big-endian uint32 length of the frame
+	REQUEST_TIMEOUT = 10 * time.Second // how long a client has to send its query
+)
+
+/* ---[ SERVER ]--- */
+
+//
+// The query server protocol is one query per connection:
+//
+//  1. the client sends the common.Query as a line of JSON
+//  2. the server sends the search results, formatted as the query
+//     asks, as a series of frames, each a 4 byte big-endian length
+//     followed by that many bytes of output
+//  3. a zero length frame ends the output, and is followed by a
+//     serverReply as a line of JSON
+//
+type serverReply struct {
+	Found int    // number of matches
+	Error string // set if the query could not be run
+}
+
+//
+// memDb is a db read into memory. It is reloaded when the db file
+// on disk is replaced, eg by the indexer.
+//
+type memDb struct {
+	path    string
+	log     logger
+	mu      sync.RWMutex
+	info    *DbInfo
+	blocks  [][]byte // block payloads
+	modTime time.Time
+	size    int64
+}
+
+//
+// load reads in the db if it has changed on disk since it was last
+// loaded. It is a no-op if it hasn't.
+//
+func (db *memDb) load() error {
+	stat, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	db.mu.RLock()
+	same := db.blocks != nil && stat.ModTime().Equal(db.modTime) && stat.Size() == db.size
+	db.mu.RUnlock()
+	if same {
+		return nil
+	}
+
+	file, info, err := openDb(db.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	blocks := make([][]byte, 0, 16)
+	br := newBlockReader(file)
+	for {
+		rb, _, err := br.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", db.path, err)
+		}
+		// next reuses its buffer
+		blocks = append(blocks, append([]byte(nil), rb...))
+	}
+
+	db.mu.Lock()
+	db.info, db.blocks = info, blocks
+	db.modTime, db.size = stat.ModTime(), stat.Size()
+	db.mu.Unlock()
+	db.log.prf("Loaded %d entries in %d blocks from %s\n", info.Entries, len(blocks), db.path)
+	return nil
+}
+
+//
+// search runs q against the blocks in memory, calling found with
+// every match, until it is done or ctx is cancelled
+//
+func (db *memDb) search(ctx context.Context, q common.Query, found func(path, rec []byte)) (int, error) {
+	if err := db.load(); err != nil {
+		return 0, err
+	}
+	m, err := newMatcher(q)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid search term: %v", err)
+	}
+
+	db.mu.RLock()
+	blocks := db.blocks
+	db.mu.RUnlock()
+	i := 0
+	next := func() ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if i == len(blocks) {
+			return nil, io.EOF
+		}
+		i++
+		return blocks[i-1], nil
+	}
+	return searchBlocks(next, m, q.Limit, found)
+}
+
+//
+// socketFile returns the path of the query server socket for a db. It
+// is named after the db, so the servers for two dbs in the same dir
+// don't answer each other's queries.
+//
+func socketFile(dbFile string) string {
+	return dbFile + SOCKET_EXT
+}
+
+//
+// Serve reads the db into memory and answers queries over a Unix
+// socket next to it (see socketFile) until ctx is cancelled. The db is
+// read in again whenever it changes on disk.
+//
+func (fl BoyerFsLocate) Serve(ctx context.Context) error {
+	fl = fl.withDefaults()
+
+	log := fl.log()
+	db := &memDb{path: fl.DbFile, log: log}
+	if err := db.load(); err != nil {
+		return err
+	}
+
+	sock := socketFile(fl.DbFile)
+	ln, err := listenSocket(log, sock)
+	if err != nil {
+		return err
+	}
+	log.prf("Listening on %s\n", sock)
+
+	// closing the listener also removes the socket file
+	defer ln.Close()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.prn("Shutting down")
+			ln.Close()
+		case <-stopped:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, db, conn)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+//
+// listenSocket listens on sock, removing the socket left behind
+// by a server that didn't shut down cleanly. Fails if a server is
+// already running. The db lists every file name, so only its owner
+// gets to query it: the socket is made in a dir only the owner can
+// get into and only moved to sock once it is 0600, so there is no
+// moment when anyone else can connect.
+//
+func listenSocket(log logger, sock string) (net.Listener, error) {
+	if common.FileExists(sock) {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a server is already running on %s", sock)
+		}
+		log.prn("Removing stale socket " + sock)
+		os.Remove(sock)
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(sock), ".fslocate-sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpSock := filepath.Join(tmpDir, "sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpSock, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the socket moves, so socketListener removes it instead
+	ln.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmpSock, 0600); err == nil {
+		err = os.Rename(tmpSock, sock)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &socketListener{UnixListener: ln, path: sock}, nil
+}
+
+//
+// socketListener is a listener that removes its socket file when
+// closed. Only the first Close does anything, and any others wait for
+// it, so the socket is gone once any Close returns.
+//
+type socketListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+	err  error
+}
+
+func (l *socketListener) Close() error {
+	l.once.Do(func() {
+		l.err = l.UnixListener.Close()
+		os.Remove(l.path)
+	})
+	return l.err
+}
+
+func serveConn(ctx context.Context, db *memDb, conn net.Conn) {
+	defer conn.Close()
+	start := time.Now()
+
+	var q common.Query
+	conn.SetReadDeadline(start.Add(REQUEST_TIMEOUT))
+	if err := json.NewDecoder(conn).Decode(&q); err != nil {
+		if err == io.EOF {
+			// eg another server checking if this one is running
+			return
+		}
+		db.log.warnf("Bad request: %v\n", err)
+		return
+	}
+
+	w := bufio.NewWriter(conn)
+	fw := &frameWriter{w: w}
+	out := bufio.NewWriter(fw)
+	var reply serverReply
+	nfound, err := db.search(ctx, q, newResultWriter(out, q.Format))
+	out.Flush()
+	reply.Found = nfound
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	db.log.prf("Query %q: %d matches in %v\n", q.Terms, nfound, time.Since(start))
+
+	// zero length frame ends the output
+	fw.Write(nil)
+	json.NewEncoder(w).Encode(reply)
+	if err = w.Flush(); err != nil {
+		db.log.prf("Unable to send reply: %v\n", err)
+	}
+}
+
+//
+// frameWriter writes each Write as one frame. An empty Write writes
+// the zero length frame that ends the output.
+//
+type frameWriter struct {
+	w *bufio.Writer
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	var hdr [FRAME_HEADER_SZ]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(p)))
+	if _, err := fw.w.Write(hdr[:]); err != nil {
+		return 0, err
+	}
+	return fw.w.Write(p)
+}
+
+/* ---[ CLIENT ]--- */
+
+//
+// searchServer sends q to the query server listening on sock, if there
+// is one, and copies the results to out. Returns false if there is no
+// server to talk to, in which case the caller should search the db
+// itself.
+//
+func searchServer(ctx context.Context, log logger, sock string, q common.Query, out io.Writer) (int, bool, error) {
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return 0, false, nil
+	}
+	defer conn.Close()
+	log.prn("Searching via server on " + sock)
+
+	// unblock the reads below if ctx is cancelled
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stopped:
+		}
+	}()
+	readErr := func(err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("Reading from server: %v", err)
+	}
+
+	if err = json.NewEncoder(conn).Encode(q); err != nil {
+		log.warnf("Unable to query server: %v\n", err)
+		return 0, false, nil
+	}
+
+	r := bufio.NewReader(conn)
+	var hdr [FRAME_HEADER_SZ]byte
+	for {
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			return 0, true, readErr(err)
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:]))
+		if size == 0 {
+			break
+		}
+		if _, err = io.CopyN(out, r, size); err != nil {
+			return 0, true, readErr(err)
+		}
+	}
+
+	var reply serverReply
+	if err = json.NewDecoder(r).Decode(&reply); err != nil {
+		return 0, true, readErr(err)
+	}
+	if reply.Error != "" {
+		return reply.Found, true, errors.New(reply.Error)
+	}
+	return reply.Found, true, nil
+}
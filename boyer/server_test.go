@@ -0,0 +1,168 @@
+package boyer
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quux00/fslocate/common"
+)
+
+// searchOutput runs q with fl.Search, returning what it wrote out
+func searchOutput(fl BoyerFsLocate, q common.Query) (string, int, error) {
+	var out bytes.Buffer
+	nfound, err := fl.Search(context.Background(), q, &out)
+	return out.String(), nfound, err
+}
+
+// shortTempDir is t.TempDir with a short path, as Unix socket paths are limited to around 100 bytes
+func shortTempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "fsl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeTestDb writes a db at fpath with the given listings
+func writeTestDb(t *testing.T, fpath string, lsts ...dirListing) {
+	if _, err := writeDb(context.Background(), logger{}, fpath, &DbInfo{}, feedListings(lsts)); err != nil {
+		t.Fatalf("writeDb: %v", err)
+	}
+}
+
+//
+// startServer runs fl.Serve until it is listening, returning the func
+// that stops it and waits for it to be done
+//
+func startServer(t *testing.T, fl BoyerFsLocate) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- fl.Serve(ctx)
+	}()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("unix", socketFile(fl.DbFile)); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("server did not start")
+		}
+	}
+	return func() {
+		cancel()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Fatalf("Serve: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not stop when cancelled")
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	fl := BoyerFsLocate{DbFile: filepath.Join(shortTempDir(t), "test.boyer")}
+	writeTestDb(t, fl.DbFile,
+		dirListing{dir: testEntries[0], files: testEntries[1:3]},
+		dirListing{dir: testEntries[3], files: testEntries[4:]})
+
+	// a socket left behind by a server that didn't shut down cleanly
+	sock := socketFile(fl.DbFile)
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	queries := []common.Query{
+		{Terms: []string{"go"}},
+		{Terms: []string{"go"}, Limit: 2},
+		{Terms: []string{"txt"}, Format: common.FORMAT_JSON},
+		{Terms: []string{"/"}, Format: common.FORMAT_NUL, Types: "f"},
+		{Terms: []string{"nothing"}},
+	}
+	type result struct {
+		out    string
+		nfound int
+	}
+	// with no server to talk to, the db file is searched
+	var exp []result
+	for _, q := range queries {
+		out, nfound, err := searchOutput(fl, q)
+		if err != nil {
+			t.Fatalf("Search %+v: %v", q, err)
+		}
+		exp = append(exp, result{out, nfound})
+	}
+	equals(t, result{"/usr/local/go\n/usr/local/go/README.md\n", 2}, exp[1])
+	_, _, expErr := searchOutput(fl, common.Query{Terms: []string{"("}, Regex: true})
+
+	stop := startServer(t, fl)
+	// only the owner can connect, and nothing is left from making the socket
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equals(t, os.FileMode(0600), info.Mode().Perm())
+	files, _ := os.ReadDir(filepath.Dir(sock))
+	equals(t, 2, len(files))
+	if err = fl.Serve(context.Background()); err == nil {
+		t.Error("a second server should not start")
+	}
+
+	// the server gives the same results, errors included
+	for i, q := range queries {
+		var out bytes.Buffer
+		nfound, ok, err := searchServer(context.Background(), logger{}, sock, q, &out)
+		equals(t, true, ok)
+		if err != nil {
+			t.Fatalf("searchServer %+v: %v", q, err)
+		}
+		equals(t, exp[i], result{out.String(), nfound})
+
+		out2, nfound, err := searchOutput(fl, q)
+		if err != nil {
+			t.Fatalf("Search %+v: %v", q, err)
+		}
+		equals(t, exp[i], result{out2, nfound})
+	}
+	_, _, err = searchOutput(fl, common.Query{Terms: []string{"("}, Regex: true})
+	equals(t, expErr.Error(), err.Error())
+
+	stop()
+	equals(t, false, common.FileExists(sock))
+	_, ok, _ := searchServer(context.Background(), logger{}, sock, queries[0], &bytes.Buffer{})
+	equals(t, false, ok)
+}
+
+func TestServerPerDb(t *testing.T) {
+	dir := shortTempDir(t)
+	a := BoyerFsLocate{DbFile: filepath.Join(dir, "a.boyer")}
+	b := BoyerFsLocate{DbFile: filepath.Join(dir, "b.boyer")}
+	writeTestDb(t, a.DbFile, dirListing{dir: testEntries[0], files: testEntries[1:3]})
+	writeTestDb(t, b.DbFile, dirListing{dir: testEntries[3], files: testEntries[4:]})
+
+	// the server for a doesn't answer searches of b
+	stop := startServer(t, a)
+	defer stop()
+	q := common.Query{Terms: []string{"go"}}
+	for fl, exp := range map[BoyerFsLocate]string{
+		a: "/usr/local/go\n/usr/local/go/README.md\n/usr/local/go/api/go1.txt\n",
+		b: "/home/quux00/golang\n/home/quux00/golang/main.go\n",
+	} {
+		out, _, err := searchOutput(fl, q)
+		if err != nil {
+			t.Fatalf("Search %s: %v", fl.DbFile, err)
+		}
+		equals(t, exp, out)
+	}
+}
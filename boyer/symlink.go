@@ -0,0 +1,78 @@
+package boyer
+
+import (
+	"os"
+
+	"github.com/quux00/fslocate/common"
+)
+
+//
+// dirChain is the ids (device and inode) of the dirs a walk went
+// through to get to a dir, innermost first. A symlink to any of them
+// would be a cycle.
+//
+type dirChain struct {
+	dev, ino uint64
+	parent   *dirChain
+}
+
+func (c *dirChain) contains(dev, ino uint64) bool {
+	for ; c != nil; c = c.parent {
+		if c.dev == dev && c.ino == ino {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// enter returns d with the dir with the given info added to its chain,
+// if its root follows symlinks and so needs the chain to spot cycles
+//
+func (d queuedDir) enter(info os.FileInfo) queuedDir {
+	if d.root.FollowSymlinks {
+		if dev, ino, ok := common.FileId(info); ok {
+			d.chain = &dirChain{dev: dev, ino: ino, parent: d.chain}
+		}
+	}
+	return d
+}
+
+//
+// followLink says if the walk should go into the symlink at path, in
+// d: its root has FollowSymlinks set and it points to a dir, which
+// isn't one of the dirs the walk went through to get to d (a cycle) or
+// on another filesystem if the root has OneFilesystem set. Symlinks
+// aren't followed on platforms where cycles can't be spotted.
+//
+func (d queuedDir) followLink(log logger, path string) bool {
+	if !d.root.FollowSymlinks {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		// dangling, or not to a dir
+		return false
+	}
+	dev, ino, ok := common.FileId(info)
+	if !ok {
+		return false
+	}
+	if d.chain.contains(dev, ino) {
+		log.prf("Not following symlink cycle: %s\n", path)
+		return false
+	}
+	if d.root.onOtherFs(info) {
+		log.prf("Not following symlink into other filesystem: %s\n", path)
+		return false
+	}
+	return true
+}
+
+// linkChild returns the queuedDir for a followed symlink to a dir in d
+func (d queuedDir) linkChild(path string) queuedDir {
+	c := d.child(path)
+	c.link = true
+	c.linked = true
+	return c
+}
@@ -0,0 +1,383 @@
+//go:build linux
+// +build linux
+
+package boyer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+const (
+	WATCH_MASK = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+		syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+		syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK
+
+	INOTIFY_BUFSZ = 64 * 1024
+)
+
+type inotifyEvent struct {
+	wd   int32
+	mask uint32
+	name string
+}
+
+//
+// watcher keeps a memIndex up to date from inotify events. There is
+// one inotify watch per dir in the index. Everything other than
+// reading the events is done on the goroutine running Watch.
+//
+type watcher struct {
+	fl         BoyerFsLocate
+	log        logger
+	fd         int
+	roots      []*root
+	ignoreFile string // the file the ignore rules came from
+	numIndexes int
+	index      *memIndex
+	wds        map[int32]string // watch descriptor => dir
+	dirWds     map[string]int32
+	ignores    map[string]*ignoreStack // dir => per-dir ignore rules for its entries
+	warnedMax  bool                    // already warned about running out of watches
+}
+
+//
+// Watch indexes the top level dirs in the index file, the same as Index,
+// but then keeps running, updating an in-memory index from inotify
+// events. The db is rewritten from the in-memory index every checkpoint
+// interval if anything has changed, and once more when ctx is cancelled.
+// If ctx is cancelled during the initial walk, the previous db is left
+// as is.
+//
+func (fl BoyerFsLocate) Watch(ctx context.Context, numIndexes int, checkpoint time.Duration) error {
+	fl = fl.withDefaults()
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("unable to start inotify: %v", err)
+	}
+	// a non-blocking fd is read through the runtime poller, so closing
+	// the file wakes up a read waiting on it
+	inotify := os.NewFile(uintptr(fd), "inotify")
+	defer inotify.Close()
+
+	roots, ignoreFile, err := fl.loadRoots()
+	if err != nil {
+		return err
+	}
+	w := &watcher{
+		fl:         fl,
+		log:        fl.log(),
+		fd:         fd,
+		roots:      roots,
+		ignoreFile: ignoreFile,
+		numIndexes: numIndexes,
+		index:      newMemIndex(),
+		wds:        make(map[int32]string),
+		dirWds:     make(map[string]int32),
+		ignores:    make(map[string]*ignoreStack),
+	}
+	w.log.prf("Read in %d top level entries\n", len(w.roots))
+
+	w.scan(ctx, startDirs(w.roots))
+	if ctx.Err() != nil {
+		w.log.prn("Cancelled during initial walk: db not written")
+		return nil
+	}
+	w.log.prf("Initial walk done: watching %d dirs\n", len(w.wds))
+	w.checkpoint()
+
+	events := make(chan inotifyEvent, 1024)
+	errs := make(chan error, 1)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		readEvents(inotify, events, errs, stop)
+		close(stopped)
+	}()
+	// the reader is done with the fd before Watch returns
+	defer func() {
+		close(stop)
+		inotify.Close()
+		<-stopped
+	}()
+
+	ticker := time.NewTicker(checkpoint)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev := <-events:
+			w.handle(ev)
+		case <-ticker.C:
+			if w.index.dirty {
+				w.checkpoint()
+			}
+		case err := <-errs:
+			return fmt.Errorf("reading inotify events: %v", err)
+		case <-ctx.Done():
+			w.log.prn("Stopping: writing db and exiting")
+			if w.index.dirty {
+				w.checkpoint()
+			}
+			return nil
+		}
+	}
+}
+
+//
+// scan walks dirs, adding everything under them to the index and
+// watching every dir it finds. Dirs reached through a followed symlink
+// aren't watched: the dir may be watched under its own path too, and
+// inotify has one watch per dir, not per path.
+//
+func (w *watcher) scan(ctx context.Context, dirs []queuedDir) {
+	for lst := range walk(ctx, w.log, dirs, nil, nil, w.numIndexes) {
+		if lst.err != nil {
+			w.log.warnf("%v\n", lst.err)
+		}
+		for _, err := range lst.skipped {
+			w.log.warnf("Skipping %v\n", err)
+		}
+		if lst.missing {
+			continue
+		}
+		w.index.addListing(lst)
+		if lst.linked {
+			continue
+		}
+		w.addWatch(lst.dir.Path)
+		if lst.ignores != nil {
+			w.ignores[lst.dir.Path] = lst.ignores
+		}
+
+		// the dir may have changed between being read and being watched
+		if info, err := os.Stat(lst.dir.Path); err == nil && info.ModTime().UnixNano() != lst.dir.Mtime {
+			w.log.prf("Dir changed while being read: %s\n", lst.dir.Path)
+			w.rescan(lst.dir.Path)
+		}
+	}
+}
+
+// rescan replaces everything under dir in the index with what is on disk now
+func (w *watcher) rescan(dir string) {
+	r, depth := rootOf(w.roots, dir)
+	if r == nil {
+		return
+	}
+	w.removePath(dir)
+	d := queuedDir{path: dir, root: r, depth: depth, ignores: w.ignores[filepath.Dir(dir)]}
+	// the walk from dir leaves out the roots nested under it, so they
+	// have to be walked again from their own entries
+	dirs := append([]queuedDir{d}, startDirs(rootsUnder(w.roots, dir))...)
+	// not cancellable, so a checkpoint never sees half a rescan
+	w.scan(context.Background(), dirs)
+}
+
+//
+// ignored says if path should not be in the index: it isn't under one
+// of the roots, matches an ignore rule or is below the root's max depth
+//
+func (w *watcher) ignored(path string, isDir bool) bool {
+	r, depth := rootOf(w.roots, path)
+	if r == nil || (r.MaxDepth > 0 && depth > r.MaxDepth) {
+		return true
+	}
+	d := queuedDir{path: filepath.Dir(path), root: r, ignores: w.ignores[filepath.Dir(path)]}
+	return d.ignoredBy(path, isDir) != nil
+}
+
+func (w *watcher) addWatch(dir string) {
+	if _, ok := w.dirWds[dir]; ok {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, WATCH_MASK)
+	if err != nil {
+		if err == syscall.ENOSPC {
+			if !w.warnedMax {
+				w.log.warnf("Out of inotify watches: changes in some dirs will be missed."+
+					" Raise fs.inotify.max_user_watches with sysctl\n")
+				w.warnedMax = true
+			}
+		} else {
+			w.log.warnf("Unable to watch %s: %v\n", dir, err)
+		}
+		return
+	}
+	w.wds[int32(wd)] = dir
+	w.dirWds[dir] = int32(wd)
+}
+
+func (w *watcher) removeWatch(dir string) {
+	wd, ok := w.dirWds[dir]
+	if !ok {
+		return
+	}
+	// fails harmlessly if the kernel already removed the watch
+	syscall.InotifyRmWatch(w.fd, uint32(wd))
+	delete(w.wds, wd)
+	delete(w.dirWds, dir)
+	delete(w.ignores, dir)
+}
+
+func (w *watcher) handle(ev inotifyEvent) {
+	if ev.mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.log.warnf("inotify queue overflowed: rescanning all dirs\n")
+		for _, r := range w.roots {
+			w.removePath(r.Path)
+		}
+		w.scan(context.Background(), startDirs(w.roots))
+		return
+	}
+
+	dir, ok := w.wds[ev.wd]
+	if !ok {
+		return
+	}
+	if ev.mask&syscall.IN_IGNORED != 0 {
+		delete(w.wds, ev.wd)
+		delete(w.dirWds, dir)
+		delete(w.ignores, dir)
+		return
+	}
+
+	if ev.name == "" {
+		// event on the watched dir itself
+		if ev.mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+			w.log.prf("Removed: %s\n", dir)
+			w.removePath(dir)
+		} else {
+			w.updatePath(dir)
+		}
+		return
+	}
+
+	path := common.CreateFullPath(dir, ev.name)
+	switch {
+	case ev.mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.log.prf("Removed: %s\n", path)
+		w.removePath(path)
+		w.updatePath(dir)
+	case ev.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		w.log.prf("Added: %s\n", path)
+		w.addPath(path)
+		w.updatePath(dir)
+	default:
+		w.updatePath(path)
+	}
+	if isDirIgnoreFile(ev.name) {
+		if r, _ := rootOf(w.roots, dir); r != nil && r.DirIgnore {
+			w.log.prf("Ignore file changed: rescanning %s\n", dir)
+			w.rescan(dir)
+		}
+	}
+}
+
+func (w *watcher) addPath(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		// already gone again
+		return
+	}
+	if w.ignored(path, info.IsDir()) {
+		return
+	}
+	if info.IsDir() {
+		// it may have come with a whole tree under it (mv, mkdir -p, etc.)
+		w.rescan(path)
+	} else if e := fsentry.New(path, info); w.storable(e) {
+		w.index.set(e)
+	}
+}
+
+// updatePath refreshes the metadata of an entry already in the index
+func (w *watcher) updatePath(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || w.ignored(path, info.IsDir()) {
+		return
+	}
+	e := fsentry.New(path, info)
+	if !w.storable(e) {
+		w.removePath(path)
+		return
+	}
+	if e.Typ == fsentry.DIR {
+		if _, ok := w.index.dirs[path]; !ok {
+			return
+		}
+	}
+	w.index.set(e)
+}
+
+// storable says if e can be stored in the db, warning if not
+func (w *watcher) storable(e fsentry.E) bool {
+	if err := checkStorable(e); err != nil {
+		w.log.warnf("Skipping %v\n", err)
+		return false
+	}
+	return true
+}
+
+func (w *watcher) removePath(path string) {
+	for _, dir := range w.index.remove(path) {
+		w.removeWatch(dir)
+	}
+}
+
+// checkpoint writes the in-memory index out as the new db
+func (w *watcher) checkpoint() {
+	info := newDbInfo(rootPaths(w.roots), w.ignoreFile)
+	info.DirIgnore = w.fl.DirIgnore
+	_, err := writeDb(context.Background(), w.log, w.fl.DbFile, info, feedListings(w.index.listings()))
+	if err != nil {
+		w.log.warnf("Unable to write db %s: %v\n", w.fl.DbFile, err)
+		return
+	}
+	w.index.dirty = false
+	w.log.prf("Checkpoint: wrote %d entries to %s\n", info.Entries, w.fl.DbFile)
+}
+
+//
+// readEvents reads inotify events from r and sends them on events
+// until stop is closed or a read fails, when it sends the error on
+// errs. Closing r ends a read that is waiting for events.
+//
+func readEvents(r io.Reader, events chan<- inotifyEvent, errs chan<- error, stop <-chan struct{}) {
+	buf := make([]byte, INOTIFY_BUFSZ)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			select {
+			case <-stop:
+			case errs <- err:
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			name := buf[start : start+int(raw.Len)]
+			ev := inotifyEvent{
+				wd:   raw.Wd,
+				mask: raw.Mask,
+				name: string(bytes.TrimRight(name, "\x00")),
+			}
+			select {
+			case events <- ev:
+			case <-stop:
+				return
+			}
+			off = start + int(raw.Len)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package boyer
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/quux00/fslocate/common"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTree(t, root, map[string]string{"a.txt": "", "sub/b.txt": ""})
+	writeTree(t, dir, map[string]string{"indexlist": root, "ignore": ""})
+	fl := BoyerFsLocate{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+	}
+
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	watched := make(chan error)
+	go func() {
+		watched <- fl.Watch(ctx, 2, 10*time.Millisecond)
+	}()
+
+	// the initial walk, then a file added while watching
+	waitFor := func(exp []string) {
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			if common.FileExists(fl.DbFile) {
+				got := indexedPaths(t, fl, root)
+				if len(got) == len(exp) {
+					equals(t, exp, got)
+					return
+				}
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("db never had %v", exp)
+			}
+		}
+	}
+	waitFor([]string{"", "a.txt", "sub", "sub/b.txt"})
+	writeTree(t, root, map[string]string{"sub/c.txt": ""})
+	waitFor([]string{"", "a.txt", "sub", "sub/b.txt", "sub/c.txt"})
+
+	// stops, along with the goroutine reading the events
+	cancel()
+	select {
+	case err := <-watched:
+		if err != nil {
+			t.Fatalf("Watch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not stop when cancelled")
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running", n-before)
+	}
+}
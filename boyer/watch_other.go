@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package boyer
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//
+// Watch is only supported on Linux, where it uses inotify
+//
+func (_ BoyerFsLocate) Watch(ctx context.Context, numIndexes int, checkpoint time.Duration) error {
+	return errors.New("-watch is only supported on Linux")
+}
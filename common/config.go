@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const ConfigName = "fslocate.json"
+
+//
+// Config is the structured config file, which replaces the index list
+// and ignore file. For example:
+//
+//   {
+//     "db": "/var/cache/fslocate/fslocate.boyer",
+//     "workers": 4,
+//     "ignore": ["*.class", ".git/"],
+//     "roots": [
+//       {"path": "/home/me", "ignore": ["node_modules/"], "one_filesystem": true},
+//       {"path": "/home/me/monorepo", "dir_ignore": true},
+//       {"path": "/srv/builds", "max_depth": 3}
+//     ]
+//   }
+//
+// The ignore rules use the same syntax as the ignore file (see
+// IgnoreRule). Anchored rules in a root's ignore list are relative to
+// the root; in the global list, to /.
+//
+type Config struct {
+	Db      string       `json:"db"`      // the db file, if not given on the command line or env
+	Workers int          `json:"workers"` // number of indexer goroutines, if not given with -j
+	Ignore  []string     `json:"ignore"`  // rules for all roots
+	Roots   []RootConfig `json:"roots"`
+}
+
+//
+// RootConfig is a top level dir to index and the options for it
+//
+type RootConfig struct {
+	Path           string   `json:"path"`
+	Ignore         []string `json:"ignore"`          // rules for this root only, on top of Config.Ignore
+	MaxDepth       int      `json:"max_depth"`       // don't index more than this many levels below the root; 0 means no limit
+	FollowSymlinks bool     `json:"follow_symlinks"` // go into symlinks to dirs, other than those that make a cycle
+	OneFilesystem  bool     `json:"one_filesystem"`  // don't cross into other filesystems
+	DirIgnore      bool     `json:"dir_ignore"`      // also apply the rules in .gitignore and .fslocateignore files
+}
+
+//
+// ReadConfig reads in and checks the config file at fpath. The root
+// paths are cleaned, so /home/me/ and /home/me are the same root.
+//
+func ReadConfig(fpath string) (*Config, error) {
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", fpath, err)
+	}
+	if err = cfg.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", fpath, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) check() error {
+	if cfg.Workers < 0 {
+		return fmt.Errorf("workers can't be negative: %d", cfg.Workers)
+	}
+	seen := make(map[string]bool)
+	for i := range cfg.Roots {
+		root := &cfg.Roots[i]
+		root.Path = filepath.Clean(root.Path)
+		switch {
+		case !filepath.IsAbs(root.Path):
+			return fmt.Errorf("root path must be absolute: %q", root.Path)
+		case seen[root.Path]:
+			return fmt.Errorf("root listed twice: %s", root.Path)
+		case root.MaxDepth < 0:
+			return fmt.Errorf("max_depth can't be negative for %s", root.Path)
+		}
+		seen[root.Path] = true
+	}
+	return nil
+}
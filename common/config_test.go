@@ -0,0 +1,42 @@
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string // part of the expected error, or "" if none
+	}{
+		{`{"db": "/tmp/x.boyer", "workers": 2, "ignore": [".git/"],
+		   "roots": [{"path": "/a", "max_depth": 2}, {"path": "/b", "one_filesystem": true}]}`, ""},
+		{`{"roots": [{"path": "a"}]}`, "must be absolute"},
+		{`{"roots": [{"path": "/a"}, {"path": "/a"}]}`, "listed twice"},
+		{`{"roots": [{"path": "/a/b"}, {"path": "/a/./b/"}]}`, "listed twice"},
+		{`{"roots": [{"path": "/a", "max_depth": -1}]}`, "max_depth"},
+		{`{"workers": -1}`, "workers"},
+		{`{"roots": [{"path": "/a", "maxdepth": 2}]}`, "unknown field"},
+		{`{"roots": [`, "unexpected EOF"},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		fpath := filepath.Join(dir, ConfigName)
+		if err := ioutil.WriteFile(fpath, []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := ReadConfig(fpath)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+			} else if cfg.Workers != 2 || len(cfg.Roots) != 2 || cfg.Roots[0].MaxDepth != 2 || !cfg.Roots[1].OneFilesystem {
+				t.Errorf("%d: unexpected config: %+v", i, cfg)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%d: expected error with %q, got %v", i, tt.err, err)
+		}
+	}
+}
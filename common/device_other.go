@@ -0,0 +1,22 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package common
+
+import "os"
+
+//
+// DeviceOf returns the id of the device (filesystem) the file is on.
+// It isn't known on this platform.
+//
+func DeviceOf(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+//
+// FileId returns the device and inode of the file, which together
+// identify it. They aren't known on this platform.
+//
+func FileId(info os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// SizeFilter matches entries by size, like find's -size: "+N" is more
+// than N, "-N" is less than N and "N" is exactly N. N can have a suffix
+// of k, M, G or T for KiB, MiB, GiB and TiB (default is bytes). As with
+// find, sizes are rounded up to the unit before comparing, so "-1M"
+// only matches empty files.
+//
+type SizeFilter struct {
+	Op   byte  // '+', '-' or '='
+	Size int64 // in units
+	Unit int64 // in bytes
+}
+
+var sizeUnits = map[byte]int64{
+	'c': 1,
+	'k': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+}
+
+func ParseSizeFilter(arg string) (*SizeFilter, error) {
+	f := &SizeFilter{Op: '=', Unit: 1}
+	s := arg
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		f.Op = s[0]
+		s = s[1:]
+	}
+	if len(s) > 0 {
+		if unit, ok := sizeUnits[s[len(s)-1]]; ok {
+			f.Unit = unit
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid size: %q", arg)
+	}
+	f.Size = n
+	return f, nil
+}
+
+func (f *SizeFilter) Match(size int64) bool {
+	units := (size + f.Unit - 1) / f.Unit
+	switch f.Op {
+	case '+':
+		return units > f.Size
+	case '-':
+		return units < f.Size
+	default:
+		return units == f.Size
+	}
+}
+
+//
+// ParseTypes parses the argument to the -type search filter: one or
+// more of the fsentry types, optionally separated by commas, eg "f,l".
+// A leading ^ means all types but those, so "^l" leaves out symlinks.
+//
+func ParseTypes(s string) (string, error) {
+	if strings.HasPrefix(s, "^") {
+		not, err := ParseTypes(s[1:])
+		if err != nil {
+			return "", err
+		}
+		var types string
+		for _, typ := range []string{fsentry.FILE, fsentry.DIR, fsentry.SYMLINK, fsentry.OTHER} {
+			if !strings.Contains(not, typ) {
+				types += typ
+			}
+		}
+		return types, nil
+	}
+	var types string
+	for _, typ := range strings.Replace(s, ",", "", -1) {
+		switch string(typ) {
+		case fsentry.FILE, fsentry.DIR, fsentry.SYMLINK, fsentry.OTHER:
+			types += string(typ)
+		default:
+			return "", fmt.Errorf("unknown type: %c", typ)
+		}
+	}
+	return types, nil
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+//
+// ParseDate parses a date/time the way ParseNewer does, but without
+// falling back to a file's mtime, for where looking at files isn't
+// wanted
+//
+func ParseDate(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("not a date: %q", s)
+}
+
+//
+// ParseNewer parses the argument to the -newer search filter. That is
+// either a date/time (RFC 3339 or a prefix of "2006-01-02T15:04:05" in
+// local time), or the path of a file, whose mtime is used.
+//
+func ParseNewer(s string) (time.Time, error) {
+	if t, err := ParseDate(s); err == nil {
+		return t, nil
+	}
+	if info, err := os.Stat(s); err == nil {
+		return info.ModTime(), nil
+	}
+	return time.Time{}, fmt.Errorf("not a date or an existing file: %q", s)
+}
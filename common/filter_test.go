@@ -0,0 +1,82 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSizeFilter(t *testing.T) {
+	tests := []struct {
+		arg  string
+		size int64
+		exp  bool
+	}{
+		{"+100M", 100<<20 + 1, true},
+		{"+100M", 100 << 20, false},
+		{"-1k", 0, true},
+		{"-1k", 1, false},
+		{"10", 10, true},
+		{"10c", 11, false},
+		{"2G", 2<<30 - 100, true},
+		{"+0", 1, true},
+	}
+	for _, tt := range tests {
+		f, err := ParseSizeFilter(tt.arg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.arg, err)
+			continue
+		}
+		if f.Match(tt.size) != tt.exp {
+			t.Errorf("%s with size %d: expected %v", tt.arg, tt.size, tt.exp)
+		}
+	}
+
+	for _, arg := range []string{"", "+", "10x", "M", "--1"} {
+		if _, err := ParseSizeFilter(arg); err == nil {
+			t.Errorf("%q: expected error", arg)
+		}
+	}
+}
+
+func TestParseNewer(t *testing.T) {
+	tm, err := ParseNewer("2026-01-01")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !tm.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("%v", tm)
+	}
+
+	tm, err = ParseNewer("2026-01-01T10:00:00Z")
+	if err != nil || !tm.Equal(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("%v: %v", tm, err)
+	}
+
+	if _, err = ParseNewer("filter.go"); err != nil {
+		t.Errorf("%v", err)
+	}
+	if _, err = ParseNewer("not-a-date"); err == nil {
+		t.Errorf("expected error")
+	}
+
+	// ParseDate takes the same dates, but not files
+	if tm, err = ParseDate("2026-01-01"); err != nil || !tm.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("%v: %v", tm, err)
+	}
+	if _, err = ParseDate("filter.go"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	types, err := ParseTypes("f,l")
+	if err != nil || types != "fl" {
+		t.Errorf("ParseTypes(f,l) = %q, %v", types, err)
+	}
+	if _, err = ParseTypes("fx"); err == nil {
+		t.Errorf("ParseTypes(fx) should fail")
+	}
+	if types, err = ParseTypes("^l"); err != nil || types != "fdo" {
+		t.Errorf("ParseTypes(^l) = %q, %v", types, err)
+	}
+}
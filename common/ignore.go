@@ -0,0 +1,284 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const IgnoreFile = "conf/fslocate.ignore"
+
+//
+// IgnoreRule is one rule from an ignore file or the config. Rules use
+// the gitignore syntax:
+//   - a rule without a slash, like *.class or build, matches an entry
+//     with that name at any level
+//   - a rule with a slash at the start or in the middle, like /tmp or
+//     src/gen, is anchored: it matches the path relative to the base of
+//     its IgnorePatterns
+//   - a rule ending in a slash, like .git/, only matches dirs
+//   - *, ? and [a-z] match within a path element; [!a-z] is a negated
+//     class; ** as a whole element matches any number of elements
+//   - a rule starting with ! re-includes what an earlier rule ignored
+//   - a rule starting with re: is a regular expression, matched against
+//     the absolute path, with a / on the end for dirs: re:/tmp-[0-9]+/
+//     matches dirs like tmp-123 and re:/\.[0-9a-f]{40}$ matches files
+//     named with a sha1
+//
+type IgnoreRule struct {
+	Pattern string // the rule as written
+	Source  string // the file the rule came from
+	Line    int    // line number in Source, or 0 if it isn't from a line of a file
+	Negate  bool   // a ! rule
+	DirOnly bool   // a rule ending in /
+
+	elems []string       // the path elements to match; starts with ** if not anchored
+	re    *regexp.Regexp // for a re: rule
+}
+
+//
+// IgnorePatterns is an ordered list of ignore rules. Anchored rules are
+// matched against the path relative to base, or against the absolute
+// path if base is empty (as for the global ignore file).
+//
+type IgnorePatterns struct {
+	base  string
+	rules []*IgnoreRule
+}
+
+//
+// ParseIgnoreRule parses one line of an ignore file. Returns nil for
+// blank lines and comments.
+//
+func ParseIgnoreRule(ln string) (*IgnoreRule, error) {
+	ln = strings.TrimSpace(ln)
+	if ln == "" || strings.HasPrefix(ln, "#") {
+		return nil, nil
+	}
+	r := &IgnoreRule{Pattern: ln}
+	pat := ln
+	if strings.HasPrefix(pat, "!") {
+		r.Negate = true
+		pat = pat[1:]
+	}
+	if strings.HasPrefix(pat, "re:") {
+		re, err := regexp.Compile(pat[len("re:"):])
+		if err != nil {
+			return nil, fmt.Errorf("bad regexp: %q: %v", ln, err)
+		}
+		r.re = re
+		return r, nil
+	}
+	if strings.HasSuffix(pat, "/") {
+		r.DirOnly = true
+		pat = strings.TrimRight(pat, "/")
+	}
+	if pat == "" {
+		return nil, fmt.Errorf("empty pattern: %q", ln)
+	}
+
+	anchored := strings.Contains(pat, "/")
+	r.elems = strings.Split(strings.TrimPrefix(pat, "/"), "/")
+	for i, el := range r.elems {
+		// gitignore negates a class with [!...], path.Match with [^...]
+		el = strings.Replace(el, "[!", "[^", -1)
+		if _, err := path.Match(el, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern: %q", ln)
+		}
+		r.elems[i] = el
+	}
+	if !anchored {
+		r.elems = append([]string{"**"}, r.elems...)
+	}
+	return r, nil
+}
+
+//
+// NewIgnorePatterns returns the IgnorePatterns for rules in the same
+// syntax as the lines of the ignore file, from source (eg the config
+// file), with anchored rules relative to base
+//
+func NewIgnorePatterns(base, source string, rules []string) (*IgnorePatterns, error) {
+	ip := &IgnorePatterns{base: base}
+	for _, ln := range rules {
+		r, err := ParseIgnoreRule(ln)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		if r != nil {
+			r.Source = source
+			ip.rules = append(ip.rules, r)
+		}
+	}
+	return ip, nil
+}
+
+//
+// ParseIgnoreFile reads in the rules in the ignore file at fpath, with
+// anchored rules relative to base. Lines with bad patterns are skipped
+// with a warning to warn (see Warnf). Returns an error if the file
+// can't be read.
+//
+func ParseIgnoreFile(fpath, base string, warn io.Writer) (*IgnorePatterns, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ip := &IgnorePatterns{base: base}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		r, err := ParseIgnoreRule(scanner.Text())
+		if err != nil {
+			Warnf(warn, "%s:%d: %v\n", fpath, n, err)
+			continue
+		}
+		if r != nil {
+			r.Source, r.Line = fpath, n
+			ip.rules = append(ip.rules, r)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading in %v: %v", fpath, err)
+	}
+	return ip, nil
+}
+
+//
+// Reads in the ingore patterns from IgnoreFile
+// and returns the entries as an IgnorePatterns struct
+//
+func ReadInIgnorePatterns() *IgnorePatterns {
+	return ReadIgnoreFile(IgnoreFile, os.Stderr)
+}
+
+//
+// ReadIgnoreFile is ReadInIgnorePatterns for an ignore file other
+// than IgnoreFile, with the warnings going to warn. Returns nil if
+// the file can't be read.
+//
+func ReadIgnoreFile(ignoreFile string, warn io.Writer) *IgnorePatterns {
+	if !FileExists(ignoreFile) {
+		Warnf(warn, "Unable to find ignore patterns file: %v\n", ignoreFile)
+		return nil
+	}
+	ip, err := ParseIgnoreFile(ignoreFile, "", warn)
+	if err != nil {
+		Warnf(warn, "%v\n", err)
+		return nil
+	}
+	return ip
+}
+
+//
+// Match returns the last rule that matches abspath, or nil if none do
+// or abspath isn't under the base. abspath is ignored if the rule
+// returned isn't a negation. Only abspath itself is checked, not the
+// dirs it is in: a walk doesn't go into an ignored dir, so never asks
+// about what is in it.
+//
+func (ip *IgnorePatterns) Match(abspath string, isDir bool) *IgnoreRule {
+	if ip == nil {
+		return nil
+	}
+	rel, ok := ip.relPath(abspath)
+	if !ok || rel == "" {
+		return nil
+	}
+	elems := strings.Split(rel, "/")
+	for i := len(ip.rules) - 1; i >= 0; i-- {
+		r := ip.rules[i]
+		if r.DirOnly && !isDir {
+			continue
+		}
+		if r.re != nil {
+			if r.matchRegexp(abspath, isDir) {
+				return r
+			}
+		} else if matchElems(r.elems, elems) {
+			return r
+		}
+	}
+	return nil
+}
+
+// matchRegexp matches a re: rule against abspath, with a / on the end for a dir
+func (r *IgnoreRule) matchRegexp(abspath string, isDir bool) bool {
+	s := filepath.ToSlash(abspath)
+	if isDir {
+		s += "/"
+	}
+	return r.re.MatchString(s)
+}
+
+// relPath returns abspath relative to ip.base, with / separators
+func (ip *IgnorePatterns) relPath(abspath string) (string, bool) {
+	if ip.base == "" {
+		return strings.TrimPrefix(filepath.ToSlash(abspath), "/"), true
+	}
+	if abspath == ip.base {
+		return "", true
+	}
+	prefix := strings.TrimSuffix(ip.base, string(os.PathSeparator)) + string(os.PathSeparator)
+	if !strings.HasPrefix(abspath, prefix) {
+		return "", false
+	}
+	return filepath.ToSlash(abspath[len(prefix):]), true
+}
+
+//
+// matchElems matches the elements of a path against those of a pattern.
+// A ** matches any number of elements, except at the end of the
+// pattern, where it has to match at least one: dir/** matches
+// everything in dir but not dir itself.
+//
+func matchElems(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				return len(elems) > 0
+			}
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pat[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+//
+// String describes where the rule came from and what it is, in the form
+// file:line: pattern
+//
+func (r *IgnoreRule) String() string {
+	if r.Line == 0 {
+		return fmt.Sprintf("%s: %s", r.Source, r.Pattern)
+	}
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Pattern)
+}
+
+//
+// Uses the ignore patterns to determine if the file/dir passed in should
+// not be indexed: it is if the last rule to match it isn't a negation.
+// isDir says if abspath is a dir, for the rules that only match dirs.
+//
+func ShouldIgnore(ignore *IgnorePatterns, abspath string, isDir bool) bool {
+	r := ignore.Match(abspath, isDir)
+	return r != nil && !r.Negate
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	tests := []struct {
+		rules []string
+		path  string
+		isDir bool
+		exp   bool
+	}{
+		// the legacy forms
+		{[]string{"*.class"}, "/home/me/src/Foo.class", false, true},
+		{[]string{"*.class"}, "/home/me/src/Foo.java", false, false},
+		{[]string{".git/"}, "/home/me/src/.git", true, true},
+		{[]string{".git/"}, "/home/me/src/.git", false, false},
+		{[]string{"class/"}, "/home/me/subclass", true, false},
+		{[]string{"lost+found"}, "/data/lost+found", true, true},
+		{[]string{"lost+found"}, "/data/lost+found.txt", false, false},
+		{[]string{"/home/me/tmp"}, "/home/me/tmp", true, true},
+		{[]string{"/home/me/tmp"}, "/home/you/home/me/tmp", true, false},
+
+		// anchored rules, ** and classes
+		{[]string{"me/src"}, "/home/me/src", true, false},
+		{[]string{"home/*/src"}, "/home/me/src", true, true},
+		{[]string{"**/gen/*.go"}, "/home/me/src/gen/x.go", false, true},
+		{[]string{"/home/**/x.go"}, "/home/x.go", false, true},
+		{[]string{"/home/me/**"}, "/home/me", true, false},
+		{[]string{"/home/me/**"}, "/home/me/a/b", false, true},
+		{[]string{"*.[oa]"}, "/lib/libc.a", false, true},
+		{[]string{"tmp-[!a-z]*"}, "/tmp/tmp-123", true, true},
+		{[]string{"tmp-[!a-z]*"}, "/tmp/tmp-abc", true, false},
+		{[]string{`\#notes`}, "/home/#notes", false, true},
+
+		// negation: the last rule to match wins
+		{[]string{"*.log", "!keep.log"}, "/var/keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "/var/drop.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "/var/keep.log", false, true},
+
+		// regexps: dirs are matched with a / on the end
+		{[]string{`re:/\.[0-9a-f]{40}$`}, "/objects/.2fd4e1c67a2d28fced849ee1bb76e7391b93eb12", false, true},
+		{[]string{`re:/\.[0-9a-f]{40}$`}, "/objects/.2fd4e1c67a", false, false},
+		{[]string{`re:/tmp-[0-9]+/`}, "/var/tmp-123", true, true},
+		{[]string{`re:/tmp-[0-9]+/`}, "/var/tmp-123", false, false},
+		{[]string{`re:/tmp-[0-9]+/`}, "/var/tmp-123x", true, false},
+		{[]string{"re:^/home/[^/]+/\\.cache/$", "!re:/me/"}, "/home/me/.cache", true, false},
+		{[]string{"re:^/home/[^/]+/\\.cache/$", "!re:/me/"}, "/home/you/.cache", true, true},
+	}
+	for _, tt := range tests {
+		ip, err := NewIgnorePatterns("", "test", tt.rules)
+		if err != nil {
+			t.Errorf("%v: %v", tt.rules, err)
+			continue
+		}
+		if got := ShouldIgnore(ip, tt.path, tt.isDir); got != tt.exp {
+			t.Errorf("%v on %s (dir %v): expected %v", tt.rules, tt.path, tt.isDir, tt.exp)
+		}
+	}
+}
+
+func TestIgnoreBase(t *testing.T) {
+	ip, err := NewIgnorePatterns("/home/me", "test", []string{"/build/", "docs/*.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	equals(t, true, ShouldIgnore(ip, "/home/me/build", true))
+	equals(t, false, ShouldIgnore(ip, "/home/me/src/build", true))
+	equals(t, true, ShouldIgnore(ip, "/home/me/docs/index.html", false))
+	equals(t, false, ShouldIgnore(ip, "/home/you/docs/index.html", false))
+	equals(t, false, ShouldIgnore(ip, "/home/me", true))
+
+	if _, err = NewIgnorePatterns("", "test", []string{"[a-"}); err == nil {
+		t.Errorf("expected an error for a bad pattern")
+	}
+	if _, err = NewIgnorePatterns("", "test", []string{"re:(a"}); err == nil {
+		t.Errorf("expected an error for a bad regexp")
+	}
+}
+
+func TestParseIgnoreFile(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "ignore")
+	content := "# comment\n\n*.class\n[bad\n  build/  \n"
+	if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var warn bytes.Buffer
+	ip, err := ParseIgnoreFile(fpath, "", &warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(warn.String(), "WARN: "+fpath+":4: ") {
+		t.Errorf("expected a warning for line 4, got %q", warn.String())
+	}
+	r := ip.Match("/src/build", true)
+	if r == nil {
+		t.Fatalf("expected build/ to match")
+	}
+	equals(t, "build/", r.Pattern)
+	equals(t, fpath, r.Source)
+	equals(t, 5, r.Line)
+	equals(t, 2, len(ip.rules))
+}
+
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n",
+			filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
@@ -0,0 +1,36 @@
+package common
+
+import "time"
+
+// OutputFormat says how search results are written out
+type OutputFormat int
+
+const (
+	FORMAT_LINES OutputFormat = iota // one path per line
+	FORMAT_NUL                       // each path terminated by a NUL byte
+	FORMAT_JSON                      // one JSON object per line
+)
+
+//
+// Query holds the search terms and the options that say
+// how they should be matched against the entries in the db.
+// An entry matches if it matches all of Terms (or any of them,
+// if Any is set) and none of Exclude.
+//
+type Query struct {
+	Terms    []string // what to search for
+	Exclude  []string // entries matching any of these are left out
+	Any      bool     // match entries matching any of Terms, not all
+	Regex    bool     // the terms are Go regexps rather than literal substrings
+	Glob     bool     // the terms are shell globs rather than literal substrings
+	Basename bool     // match the terms against the last element of each path only
+	FoldCase bool     // ignore case when matching
+	Limit    int      // stop after this many matches, if > 0
+
+	// filters on the metadata of the entries; the zero values mean no filter
+	Types string      // the fsentry types to match, eg "fd" for files and dirs
+	Size  *SizeFilter // size of the entry
+	Newer time.Time   // only entries modified after this time
+
+	Format OutputFormat // how to write out the matches
+}
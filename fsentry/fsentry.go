@@ -1,15 +1,75 @@
 package fsentry
 
+import "os"
+
 const (
-	DIR  = "d"
-	FILE = "f"
+	DIR     = "d"
+	FILE    = "f"
+	SYMLINK = "l"
+	OTHER   = "o" // devices, sockets, named pipes, etc.
 )
 
-// corresponds to the fslocate database table 'fsentry'
+// corresponds to a record in the fslocate database
 type E struct {
-	Path       string // full path for file or dir
-	Typ        string // DIR_TYPE or FILE_TYPE
-	IsTopLevel bool   // true = specified in the user's config/index file
+	Path       string      // full path for file or dir
+	Typ        string      // DIR, FILE, SYMLINK or OTHER
+	IsTopLevel bool        // true = specified in the user's config/index file
+	Size       int64       // in bytes
+	Mtime      int64       // modification time in Unix nanoseconds
+	Mode       os.FileMode // permission bits
+	Target     string      // what a symlink points to; set on a DIR for a followed symlink
+}
+
+//
+// New creates an entry for path from its FileInfo. For a symlink, the
+// link is read for its target.
+//
+func New(path string, info os.FileInfo) E {
+	e := E{
+		Path:  path,
+		Typ:   TypeOf(info),
+		Size:  info.Size(),
+		Mtime: info.ModTime().UnixNano(),
+		Mode:  info.Mode().Perm(),
+	}
+	if e.Typ == SYMLINK {
+		e.Target, _ = os.Readlink(path)
+	}
+	return e
+}
+
+//
+// IsLink says if the entry is a symlink: either one that wasn't
+// followed, or a dir that was reached by following one
+//
+func (e E) IsLink() bool {
+	return e.Typ == SYMLINK || e.Target != ""
+}
+
+//
+// LinkType is the entry's type, except that it is SYMLINK for a
+// followed symlink to a dir. This is the type search filters match.
+//
+func (e E) LinkType() string {
+	if e.IsLink() {
+		return SYMLINK
+	}
+	return e.Typ
+}
+
+// TypeOf returns the entry type for a FileInfo
+func TypeOf(info os.FileInfo) string {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return DIR
+	case mode.IsRegular():
+		return FILE
+	case mode&os.ModeSymlink != 0:
+		return SYMLINK
+	default:
+		return OTHER
+	}
 }
 
 
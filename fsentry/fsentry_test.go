@@ -1,6 +1,9 @@
 package fsentry
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -138,3 +141,39 @@ func TestDifferenceBothListsHaveUniqueEntries(t *testing.T) {
 		t.Errorf("should not contain: %v", ls1[0])
 	}
 }
+
+func TestNewFromFileInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsentry")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "foo")
+	if err = ioutil.WriteFile(fpath, []byte("hello"), 0640); err != nil {
+		t.Fatalf("%v", err)
+	}
+	lpath := filepath.Join(dir, "link")
+	if err = os.Symlink(fpath, lpath); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	info, _ := os.Lstat(fpath)
+	e := New(fpath, info)
+	if e.Path != fpath || e.Typ != FILE || e.Size != 5 || e.Mode != 0640 {
+		t.Errorf("%+v", e)
+	}
+	if e.Mtime != info.ModTime().UnixNano() {
+		t.Errorf("%+v", e)
+	}
+
+	info, _ = os.Lstat(dir)
+	if e = New(dir, info); e.Typ != DIR {
+		t.Errorf("%+v", e)
+	}
+
+	info, _ = os.Lstat(lpath)
+	if e = New(lpath, info); e.Typ != SYMLINK {
+		t.Errorf("%+v", e)
+	}
+}
@@ -1,50 +1,158 @@
 package main
 
 import (
+	"context"
 	"flag"
 	. "fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/pprof"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/quux00/fslocate/boyer"
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/locate"
 )
 
 var verbose bool
 var doIndexing bool
-var implType string = "boyer"
-var cpuprofile string
+var numIndexers int
+var doUpdate bool
+var dirIgnore bool
+var doWatch bool
+var doServe bool
+var httpAddr string
+var checkpoint time.Duration
+var errLog string
+var showInfo bool
+var explainPath string
+var regexSearch bool
+var globSearch bool
+var basenameSearch bool
+var foldCase bool
+var anyTerm bool
+var excludeTerms stringList
+var limit int
+var countOnly bool
+var quiet bool
+var nulOutput bool
+var jsonOutput bool
+var typeFilter string
+var sizeFilter string
+var newerFilter string
 
-//
-// FsLocate defines the interface that all implementations
-// must provide to the fslocate program.
-//
-type FsLocate interface {
-	Search(s string)
-	Index(numIndexes int, verbose bool)
-}
+// exit code when the indexer finished but had to skip some dirs
+const EXIT_PARTIAL_INDEX = 2
+
+// exit code when a search finds nothing
+const EXIT_NOT_FOUND = 1
+
+// exit code when a search fails (bad query, no db, timed out, etc.), as
+// for grep, so scripts can tell it from EXIT_NOT_FOUND
+const EXIT_SEARCH_ERROR = 2
+
+var searchTimeout time.Duration
+var dbFile string
+var confDir string
+var cpuprofile string
 
 func init() {
 	flag.BoolVar(&verbose, "v", false, "verbose")
 	flag.BoolVar(&doIndexing, "i", false, "index the config dirs (not search)")
+	flag.IntVar(&numIndexers, "j", 3, "number of indexer goroutines to run")
+	flag.BoolVar(&doUpdate, "u", false, "index, only re-reading dirs changed since the last index")
+	flag.BoolVar(&dirIgnore, "dirignore", false, "also apply the .gitignore and .fslocateignore files in the dirs indexed")
+	flag.BoolVar(&doWatch, "watch", false, "index, then keep the db up to date as files change (Linux only)")
+	flag.DurationVar(&checkpoint, "checkpoint", 5*time.Minute, "how often -watch writes out the db if anything changed")
+	flag.BoolVar(&doServe, "serve", false, "load the db into memory and answer searches over a Unix socket")
+	flag.StringVar(&httpAddr, "http", "", "serve a JSON search API on this address, eg :8080")
+	flag.BoolVar(&regexSearch, "r", false, "search term is a regular expression")
+	flag.BoolVar(&globSearch, "g", false, "search term is a shell glob")
+	flag.BoolVar(&basenameSearch, "b", false, "match the search term against file basenames only")
+	flag.BoolVar(&foldCase, "I", false, "ignore case when searching")
+	flag.BoolVar(&anyTerm, "o", false, "match entries with any of the search terms, not all of them")
+	flag.Var(&excludeTerms, "x", "leave out entries matching this term (can be repeated)")
+	flag.IntVar(&limit, "n", 0, "stop after this many matches")
+	flag.BoolVar(&countOnly, "c", false, "print the number of matches, not the matches")
+	flag.BoolVar(&quiet, "q", false, "print nothing, just exit 0 if there are any matches and 1 if not")
+	flag.BoolVar(&nulOutput, "0", false, "end each match with a NUL instead of a newline (for xargs -0)")
+	flag.BoolVar(&jsonOutput, "json", false, "print each match as a JSON object")
+	flag.StringVar(&typeFilter, "type", "", "only match entries of these types: f (file), d (dir), l (symlink), o (other); ^ for all but")
+	flag.StringVar(&sizeFilter, "size", "", "only match entries of this size: [+-]N[ckMGT]")
+	flag.StringVar(&newerFilter, "newer", "", "only match entries modified after this date (or file's mtime)")
+	flag.DurationVar(&searchTimeout, "timeout", 0, "give up on a search after this long, eg 2s (default no limit)")
+	flag.StringVar(&dbFile, "db", "", "the db file to use (default $"+locate.DB_ENV+", ./db or $XDG_DATA_HOME/fslocate)")
+	flag.StringVar(&confDir, "conf", "", "the dir with the config files (default $"+locate.CONF_ENV+", ./conf or $XDG_CONFIG_HOME/fslocate)")
+	flag.BoolVar(&showInfo, "info", false, "print info about the current db")
+	flag.StringVar(&explainPath, "explain", "", "show why a path is or isn't indexed")
+	flag.StringVar(&errLog, "errlog", "", "write the dirs the indexer had to skip to this file")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
 }
 
 //
 // To search existing db, invoke with:
-//   fslocate search-term
+//   fslocate search-term [search-term ...]
 //
 // To rebuild db:
 //   fslocate -i
 //
+// To update db, re-reading only dirs that have changed:
+//   fslocate -u
+//
+// To keep the db up to date as files change:
+//   fslocate -watch
+//
+// To answer searches from memory, run a query server:
+//   fslocate -serve
+//
+// To serve a JSON search API over HTTP:
+//   fslocate -http :8080
+//
+// To see when and how the db was built:
+//   fslocate -info
+//
+// To see why a path is or isn't indexed:
+//   fslocate -explain PATH
+//
 // To see full usage, see the help function.
 //
 func main() {
 	checkArgs()
-	flag.Parse()
+	terms := parseArgs(os.Args[1:])
 
-	fslocate := getImpl(implType)
+	opts := locate.Options{
+		Update:    doUpdate,
+		DirIgnore: dirIgnore,
+		Warnings:  os.Stderr,
+		Verbose:   verbose,
+	}
+	// -j overrides the config file, which overrides the -j default
+	if isFlagSet("j") {
+		opts.NumIndexers = numIndexers
+	}
+	opts, err := opts.WithPaths(dbFile, confDir)
+	if err != nil {
+		if isSearch() {
+			searchFatalf("ERROR: %v\n", err)
+		}
+		log.Fatalf("ERROR: %v\n", err)
+	}
+	if opts.NumIndexers == 0 {
+		opts.NumIndexers = numIndexers
+	}
+
+	// on SIGINT or SIGTERM, cancel what's running so it can clean up;
+	// a second signal kills the program as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
@@ -55,34 +163,309 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	if doIndexing {
-		fslocate.Index(1, verbose)
+	if showInfo {
+		printInfo(opts)
+	} else if explainPath != "" {
+		explain(ctx, opts, explainPath)
+	} else if doWatch {
+		if err := locate.Watch(ctx, opts, checkpoint); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+	} else if doServe {
+		if err := locate.Serve(ctx, opts); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+	} else if httpAddr != "" {
+		if err := locate.ServeHttp(ctx, opts, httpAddr); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+	} else if doIndexing || doUpdate {
+		stats, err := locate.Index(ctx, opts)
+		if err == context.Canceled {
+			log.Fatalln("ERROR: Indexing interrupted: the previous db is left as is")
+		} else if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		if len(stats.Skipped) > 0 {
+			reportIndexFailures(stats.Skipped)
+			pprof.StopCPUProfile()
+			os.Exit(EXIT_PARTIAL_INDEX)
+		}
 	} else {
-		fslocate.Search(getSearchTerm(os.Args[1:]))
+		if nfound := search(ctx, opts, getQuery(terms)); nfound == 0 {
+			pprof.StopCPUProfile()
+			os.Exit(EXIT_NOT_FOUND)
+		}
 	}
 }
 
-func getImpl(fstype string) FsLocate {
-	return boyer.BoyerFsLocate{}
+//
+// reportIndexFailures prints a summary of the dirs that could not be
+// indexed to stderr. The full list goes to the errlog file if one was
+// specified, otherwise to stderr as well.
+//
+func reportIndexFailures(failures []error) {
+	Fprintf(os.Stderr, "WARN: index is partial: %d dirs or entries could not be indexed\n", len(failures))
+
+	out := os.Stderr
+	if errLog != "" {
+		f, err := os.Create(errLog)
+		if err != nil {
+			Fprintf(os.Stderr, "WARN: Unable to create %s: %v\n", errLog, err)
+		} else {
+			defer f.Close()
+			out = f
+			Fprintf(os.Stderr, "WARN: list of unread dirs written to %s\n", errLog)
+		}
+	}
+	for _, err := range failures {
+		Fprintln(out, err)
+	}
 }
 
-func getSearchTerm(args []string) string {
-	nonflagArgs := removeFlags(args)
-	if len(nonflagArgs) == 0 {
-		Fprintln(os.Stderr, "ERROR: No search term provided")
+//
+// search runs the query, printing what the -c and -q flags ask for,
+// and returns the number of matches
+//
+func search(ctx context.Context, opts locate.Options, q common.Query) int {
+	out := io.Writer(os.Stdout)
+	switch {
+	case quiet:
+		// one match is enough to know the answer
+		q.Limit = 1
+		out = ioutil.Discard
+	case countOnly:
+		out = ioutil.Discard
+	}
+
+	if searchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, searchTimeout)
+		defer cancel()
+	}
+
+	nfound, err := locate.SearchTo(ctx, q, opts, out)
+	switch {
+	case err == context.DeadlineExceeded:
+		searchFatalf("ERROR: Search timed out after %v\n", searchTimeout)
+	case err == context.Canceled:
+		searchFatalf("ERROR: Search interrupted\n")
+	case err != nil:
+		searchFatalf("ERROR: %v\n", err)
+	}
+	if countOnly && !quiet {
+		Println(nfound)
+	}
+	return nfound
+}
+
+// isSearch says if the command line is for a search, not one of the other modes
+func isSearch() bool {
+	return !showInfo && explainPath == "" && !doWatch && !doServe && httpAddr == "" && !doIndexing && !doUpdate
+}
+
+//
+// searchFatalf logs the error and exits with EXIT_SEARCH_ERROR, for
+// errors that stop a search
+//
+func searchFatalf(format string, vals ...interface{}) {
+	log.Printf(format, vals...)
+	pprof.StopCPUProfile()
+	os.Exit(EXIT_SEARCH_ERROR)
+}
+
+//
+// explain prints whether path would be indexed and why, and if it is
+// in the db
+//
+func explain(ctx context.Context, opts locate.Options, path string) {
+	ex, err := locate.Explain(ctx, path, opts)
+	if err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
+	Printf("Path:        %s\n", ex.Path)
+	if !ex.Exists {
+		Println("Exists:      no")
+	}
+	if ex.Root == "" {
+		Println("Root:        none: not under any of the dirs to index")
+	} else {
+		Printf("Root:        %s (depth %d)\n", ex.Root, ex.Depth)
+	}
+	if ex.Rule != nil {
+		verb := "Ignored by: "
+		if ex.Rule.Negate {
+			verb = "Included by:"
+		}
+		Printf("%s %s\n", verb, ex.Rule)
+		if ex.RulePath != ex.Path {
+			Printf("             (matches %s)\n", ex.RulePath)
+		}
+	}
+	if ex.Excluded != "" {
+		Printf("Excluded:    %s\n", ex.Excluded)
+	}
+	if ex.Indexed() && !ex.Exists {
+		Println("Indexed:     no, but would be if it existed")
+	} else {
+		Printf("Indexed:     %s\n", yesNo(ex.Indexed()))
+	}
+	if ex.DbErr != nil {
+		Printf("In db:       unknown: %v\n", ex.DbErr)
+	} else {
+		Printf("In db:       %s\n", yesNo(ex.InDb))
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+//
+// printInfo prints the metadata from the db header
+//
+func printInfo(opts locate.Options) {
+	info, err := locate.Info(opts)
+	if err != nil {
+		Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
 	}
-	return nonflagArgs[len(nonflagArgs)-1]
+
+	Printf("Database:     %s\n", opts.DbFile)
+	Printf("Version:      %d\n", info.Version)
+	Printf("Created:      %s\n", info.Created.Format(time.RFC3339))
+	Printf("Duration:     %s\n", info.Duration)
+	Printf("Block size:   %d\n", info.BlockSize)
+	Printf("Entries:      %d\n", info.Entries)
+	Printf("Files:        %d\n", info.Files)
+	Printf("Dirs:         %d\n", info.Dirs)
+	Printf("Skipped:      %d\n", info.Skipped)
+	if common.FileExists(opts.ConfigFile) {
+		Printf("Config file:  %s\n", opts.ConfigFile)
+	} else {
+		Printf("Index file:   %s\n", opts.IndexFile)
+	}
+	Printf("Ignore file:  %s\n", info.IgnoreFile)
+	Printf("Ignore hash:  %s\n", info.IgnoreHash)
+	Println("Roots:")
+	for _, root := range info.Roots {
+		Printf("  %s\n", root)
+	}
+}
+
+// isFlagSet says if the flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+//
+// stringList is a flag that can be given more than once
+//
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+//
+// parseArgs parses the command line flags and returns the search terms.
+// Unlike flag.Parse, it lets flags come after or in between the terms.
+//
+func parseArgs(args []string) []string {
+	var terms []string
+	for {
+		flag.CommandLine.Parse(args)
+		rest := flag.Args()
+		if len(rest) == 0 {
+			return terms
+		}
+		// after a "--" everything is a search term
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(terms, rest...)
+		}
+		terms = append(terms, rest[0])
+		args = rest[1:]
+	}
 }
 
-func removeFlags(args []string) []string {
-	var nonflags []string
-	for _, arg := range args {
-		if !strings.HasPrefix(arg, "-") {
-			nonflags = append(nonflags, arg)
+//
+// getQuery builds the query from the search terms and flags.
+// A term starting with '!' is one to exclude, like -x. To search
+// for a term that really starts with '!', escape it as '\!'.
+//
+func getQuery(terms []string) common.Query {
+	q := common.Query{
+		Exclude:  excludeTerms,
+		Any:      anyTerm,
+		Regex:    regexSearch,
+		Glob:     globSearch,
+		Basename: basenameSearch,
+		FoldCase: foldCase,
+		Limit:    limit,
+	}
+	addFilters(&q)
+	switch {
+	case nulOutput && jsonOutput:
+		Fprintln(os.Stderr, "ERROR: -0 and -json can't be used together")
+		os.Exit(EXIT_SEARCH_ERROR)
+	case nulOutput:
+		q.Format = common.FORMAT_NUL
+	case jsonOutput:
+		q.Format = common.FORMAT_JSON
+	}
+
+	for _, term := range terms {
+		if strings.HasPrefix(term, "!") && len(term) > 1 {
+			q.Exclude = append(q.Exclude, term[1:])
+		} else if strings.HasPrefix(term, "\\!") {
+			q.Terms = append(q.Terms, term[1:])
+		} else {
+			q.Terms = append(q.Terms, term)
+		}
+	}
+	if len(q.Terms) == 0 && len(q.Exclude) == 0 && typeFilter == "" && sizeFilter == "" && newerFilter == "" {
+		Fprintln(os.Stderr, "ERROR: No search term provided")
+		os.Exit(EXIT_SEARCH_ERROR)
+	}
+	return q
+}
+
+//
+// addFilters adds the -type, -size and -newer filters to q
+//
+func addFilters(q *common.Query) {
+	var err error
+	if q.Types, err = common.ParseTypes(typeFilter); err != nil {
+		Fprintf(os.Stderr, "ERROR: -type: %v\n", err)
+		os.Exit(EXIT_SEARCH_ERROR)
+	}
+	if sizeFilter != "" {
+		if q.Size, err = common.ParseSizeFilter(sizeFilter); err != nil {
+			Fprintf(os.Stderr, "ERROR: -size: %v\n", err)
+			os.Exit(EXIT_SEARCH_ERROR)
+		}
+	}
+	if newerFilter != "" {
+		if q.Newer, err = common.ParseNewer(newerFilter); err != nil {
+			Fprintf(os.Stderr, "ERROR: -newer: %v\n", err)
+			os.Exit(EXIT_SEARCH_ERROR)
 		}
 	}
-	return nonflags
 }
 
 func checkArgs() {
@@ -98,9 +481,36 @@ func checkArgs() {
 }
 
 func help() {
-	Println("Usage: [-hv] fslocate search-term | -i")
-	Println("  fslocate <search-term>")
+	Println("Usage: [-hvrgbI] [-j NUM] fslocate search-term | -i | -u | -watch | -serve | -http ADDR | -info | -explain PATH")
+	Println("  fslocate <search-term> [<search-term> ...]  (entries matching all terms)")
+	Println("  fslocate -r <regex>  (search with a regular expression)")
+	Println("  fslocate -g <glob>  (search with a shell glob; ** matches any number of dirs)")
 	Println("  fslocate -i  (run the indexer)")
+	Println("  fslocate -u  (run the indexer, only re-reading changed dirs)")
+	Println("  fslocate -watch  (index, then keep the db up to date as files change)")
+	Println("  fslocate -serve  (answer searches from memory over a Unix socket)")
+	Println("  fslocate -http ADDR  (serve a JSON search API on ADDR, eg :8080)")
+	Println("  fslocate -info  (show info about the db)")
+	Println("  fslocate -explain PATH  (show why PATH is or isn't indexed)")
+	Println("     -b     : match the search term against basenames only")
+	Println("     -I     : ignore case when searching")
+	Println("     -o     : match entries with any of the search terms, not all")
+	Println("     -x TERM: leave out entries matching TERM (same as a term of !TERM)")
+	Println("     -n NUM : stop after NUM matches")
+	Println("     -c     : print the number of matches instead of the matches")
+	Println("     -q     : print nothing; exit status is 0 if anything matched, 1 if not, 2 on error")
+	Println("     -type TYPES : only entries of these types: f (file), d (dir), l (symlink), o (other); ^TYPES for all but")
+	Println("     -size [+-]N[ckMGT] : only entries bigger than (+), smaller than (-) or of size N")
+	Println("     -newer DATE|FILE : only entries modified after DATE or after FILE was")
+	Println("     -0     : end each match with a NUL instead of a newline")
+	Println("     -json  : print each match as a JSON object, one per line")
+	Println("     -timeout DUR : give up on a search after DUR, eg 500ms or 2s")
+	Println("     -j NUM : number of indexer goroutines (default 3)")
+	Println("     -dirignore : also apply the .gitignore and .fslocateignore files in each dir indexed")
+	Println("     -checkpoint DUR : how often -watch writes out the db (default 5m)")
+	Println("     -db FILE : use this db (default $FSLOCATE_DB, ./db or $XDG_DATA_HOME/fslocate)")
+	Println("     -conf DIR : read fslocate.json (or fslocate.indexlist and fslocate.ignore) from DIR (default $FSLOCATE_CONF, ./conf or $XDG_CONFIG_HOME/fslocate)")
+	Println("     -errlog FILE : write the dirs the indexer could not read to FILE")
 	Println("     -v     : verbose mode")
 	Println("     -h     : show help")
 }
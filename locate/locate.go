@@ -0,0 +1,189 @@
+//
+// Package locate is the API for using fslocate from a Go program. It
+// indexes file names under a set of top level dirs into a db and
+// searches that db. The fslocate command is a thin wrapper around it.
+//
+// None of the functions print anything, unless Options.Warnings or
+// Options.Verbose is set, or exit: failures are returned as errors.
+//
+package locate
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/quux00/fslocate/boyer"
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+// Query says what to search for and how, see common.Query
+type Query = common.Query
+
+// DbInfo is the metadata stored in the db header
+type DbInfo = boyer.DbInfo
+
+// Explanation says why a path is or isn't indexed, see Explain
+type Explanation = boyer.Explanation
+
+const DEFAULT_NUM_INDEXERS = 3
+
+//
+// Options says where the db and config files are and how to index.
+// The zero value uses the same files as the fslocate command run from
+// the fslocate dir: db/fslocate.boyer, conf/fslocate.indexlist and
+// conf/fslocate.ignore. If ConfigFile is set and exists, the dirs to
+// index and the ignore rules are read from it instead of the index
+// list and ignore file (see common.Config).
+//
+type Options struct {
+	DbFile      string // the db to write and search
+	ConfigFile  string // the structured config file
+	IndexFile   string // the list of top level dirs to index
+	IgnoreFile  string // the patterns of files and dirs not to index
+	NumIndexers int    // number of dirs to read at once; default DEFAULT_NUM_INDEXERS
+	Update      bool   // only re-read dirs that changed since the last index
+	DirIgnore   bool      // also apply .gitignore and .fslocateignore files in the dirs indexed
+	Warnings    io.Writer // where to write warnings (eg os.Stderr); nil for none
+	Verbose     bool      // print progress to stdout
+}
+
+func (opts Options) impl() boyer.BoyerFsLocate {
+	return boyer.BoyerFsLocate{
+		DbFile:     opts.DbFile,
+		ConfigFile: opts.ConfigFile,
+		IndexFile:  opts.IndexFile,
+		IgnoreFile: opts.IgnoreFile,
+		DirIgnore:  opts.DirIgnore,
+		Warnings:   opts.Warnings,
+		Verbose:    opts.Verbose,
+	}
+}
+
+func (opts Options) numIndexers() int {
+	if opts.NumIndexers < 1 {
+		return DEFAULT_NUM_INDEXERS
+	}
+	return opts.NumIndexers
+}
+
+//
+// Stats says what an Index run did
+//
+type Stats struct {
+	Entries  int64         // entries written to the db
+	Files    int64         // ... that are not dirs
+	Dirs     int64         // ... that are dirs
+	Duration time.Duration // how long it took
+	Skipped  []error       // why each dir that couldn't be read, or entry that couldn't be stored, was skipped
+}
+
+//
+// Result is an entry in the db that matched a search. The metadata is
+// what it was when the db was built. Mtime is the zero time for dirs
+// that could not be read.
+//
+type Result struct {
+	Path   string
+	Type   string // one of the fsentry types: fsentry.FILE, fsentry.DIR, ...
+	Size   int64
+	Mtime  time.Time
+	Mode   os.FileMode // permission bits only
+	Target string      // what a symlink points to, for a symlink or a followed symlink to a dir
+}
+
+//
+// Index walks the dirs listed in the index file and writes a new db.
+// Dirs that can't be read, and entries with names the db can't store
+// (with a 0x1e or 0x1f byte), are skipped and listed in Stats.Skipped,
+// so the db may be partial even if no error is returned. If ctx is
+// cancelled, indexing stops and ctx's error is returned. The previous
+// db is left as is if an error is returned.
+//
+func Index(ctx context.Context, opts Options) (Stats, error) {
+	start := time.Now()
+	info, failures, err := opts.impl().Index(ctx, opts.numIndexers(), opts.Update)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Entries:  info.Entries,
+		Files:    info.Files,
+		Dirs:     info.Dirs,
+		Duration: time.Since(start),
+		Skipped:  failures,
+	}, nil
+}
+
+//
+// Search returns the entries in the db matching q, up to q.Limit of
+// them if it is set. q.Format is ignored. If ctx is cancelled, the
+// search stops and ctx's error is returned.
+//
+func Search(ctx context.Context, q Query, opts Options) ([]Result, error) {
+	var results []Result
+	_, err := opts.impl().Find(ctx, q, func(e fsentry.E) {
+		res := Result{Path: e.Path, Type: e.Typ, Size: e.Size, Mode: e.Mode, Target: e.Target}
+		if e.Mtime != boyer.UNKNOWN_MTIME {
+			res.Mtime = time.Unix(0, e.Mtime)
+		}
+		results = append(results, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+//
+// SearchTo writes the entries in the db matching q to w, in the format
+// given by q.Format, up to q.Limit of them if it is set. If a query
+// server is running for the db (see Serve), the server does the search.
+// Returns the number of matches.
+//
+func SearchTo(ctx context.Context, q Query, opts Options, w io.Writer) (int, error) {
+	return opts.impl().Search(ctx, q, w)
+}
+
+// Info returns the metadata from the header of the db
+func Info(opts Options) (*DbInfo, error) {
+	return opts.impl().Info()
+}
+
+//
+// Explain says whether path would be indexed, and if not, why not: it
+// isn't under a root, an ignore rule matches it or a dir above it, or
+// it is beyond a max depth, symlink or filesystem boundary. Also says
+// if path is in the current db.
+//
+func Explain(ctx context.Context, path string, opts Options) (*Explanation, error) {
+	return opts.impl().Explain(ctx, path)
+}
+
+//
+// Watch indexes like Index, then keeps the db up to date as files
+// change until ctx is cancelled, writing it out every checkpoint if
+// anything changed, and once more at the end. Only supported on Linux.
+//
+func Watch(ctx context.Context, opts Options, checkpoint time.Duration) error {
+	return opts.impl().Watch(ctx, opts.numIndexers(), checkpoint)
+}
+
+//
+// Serve loads the db into memory and answers searches from SearchTo
+// (in this or other processes) over a Unix socket next to the db until
+// ctx is cancelled
+//
+func Serve(ctx context.Context, opts Options) error {
+	return opts.impl().Serve(ctx)
+}
+
+//
+// ServeHttp loads the db into memory and serves a JSON search API on
+// addr until ctx is cancelled
+//
+func ServeHttp(ctx context.Context, opts Options, addr string) error {
+	return opts.impl().ServeHttp(ctx, addr, opts.numIndexers())
+}
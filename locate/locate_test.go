@@ -0,0 +1,171 @@
+package locate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/quux00/fslocate/common"
+	"github.com/quux00/fslocate/fsentry"
+)
+
+//
+// newTestTree creates a tree of files to index, plus the index and
+// ignore files for it, and returns Options to index it with
+//
+func newTestTree(t *testing.T) Options {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tree")
+	for _, f := range []string{"src/main.go", "src/main_test.go", "src/vendor/dep.go", "README"} {
+		fpath := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := Options{
+		DbFile:     filepath.Join(dir, "test.boyer"),
+		IndexFile:  filepath.Join(dir, "indexlist"),
+		IgnoreFile: filepath.Join(dir, "ignore"),
+	}
+	if err := ioutil.WriteFile(opts.IndexFile, []byte(root+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(opts.IgnoreFile, []byte("vendor\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestIndexAndSearch(t *testing.T) {
+	opts := newTestTree(t)
+	ctx := context.Background()
+
+	stats, err := Index(ctx, opts)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if stats.Entries != 5 || stats.Dirs != 2 || len(stats.Skipped) != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	results, err := Search(ctx, Query{Terms: []string{".go"}}, opts)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	var paths []string
+	for _, res := range results {
+		paths = append(paths, filepath.Base(res.Path))
+		if res.Type != fsentry.FILE || res.Size != int64(len("src/"+filepath.Base(res.Path))) || res.Mtime.IsZero() {
+			t.Errorf("unexpected result: %+v", res)
+		}
+	}
+	if exp := []string{"main.go", "main_test.go"}; !reflect.DeepEqual(exp, paths) {
+		t.Errorf("expected %v, got %v", exp, paths)
+	}
+
+	info, err := Info(opts)
+	if err != nil || info.Entries != 5 {
+		t.Errorf("Info: %+v, %v", info, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	opts := newTestTree(t)
+	ctx := context.Background()
+
+	if _, err := Search(ctx, Query{Terms: []string{"main"}}, opts); err == nil {
+		t.Errorf("Search with no db should fail")
+	}
+	if _, err := Index(ctx, Options{IndexFile: opts.IndexFile + ".missing", DbFile: opts.DbFile}); err == nil {
+		t.Errorf("Index with no index file should fail")
+	}
+	if _, err := Index(ctx, opts); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if _, err := Search(ctx, Query{Terms: []string{"("}, Regex: true}, opts); err == nil {
+		t.Errorf("Search with a bad regexp should fail")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Search(cancelled, Query{Terms: []string{"main"}}, opts); err != context.Canceled {
+		t.Errorf("Search with a cancelled context returned %v", err)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	tree := filepath.Dir(newTestTree(t).IndexFile)
+	confDir := t.TempDir()
+	dbFile := filepath.Join(confDir, "cfg.boyer")
+	t.Setenv(DB_ENV, "")
+
+	writeConfig := func(maxDepth int) {
+		json := fmt.Sprintf(`{"db": %q, "workers": 2, "ignore": ["README"],
+			"roots": [{"path": %q, "ignore": ["vendor"], "max_depth": %d}]}`,
+			dbFile, filepath.Join(tree, "tree"), maxDepth)
+		if err := ioutil.WriteFile(filepath.Join(confDir, common.ConfigName), []byte(json), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	writeConfig(0)
+	opts, err := Options{}.WithPaths("", confDir)
+	if err != nil {
+		t.Fatalf("WithPaths: %v", err)
+	}
+	if opts.DbFile != dbFile || opts.NumIndexers != 2 {
+		t.Errorf("expected db and workers from config, got %+v", opts)
+	}
+	// the global and per-root ignore rules both apply
+	stats, err := Index(ctx, opts)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if stats.Entries != 4 || stats.Dirs != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// only the root and its direct contents are indexed at max depth 1
+	writeConfig(1)
+	if stats, err = Index(ctx, opts); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if stats.Entries != 2 || stats.Dirs != 2 {
+		t.Errorf("unexpected stats at max depth 1: %+v", stats)
+	}
+	results, err := Search(ctx, Query{Terms: []string{".go"}}, opts)
+	if err != nil || len(results) != 0 {
+		t.Errorf("expected no files below max depth, got %v, %v", results, err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	opts := newTestTree(t)
+	root, err := ioutil.ReadFile(opts.IndexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(opts.IndexFile, append(root, root...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// only written to Options.Warnings, if it is set
+	var warn bytes.Buffer
+	opts.Warnings = &warn
+	if _, err = Index(context.Background(), opts); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if !strings.Contains(warn.String(), "WARN: "+strings.TrimSpace(string(root))+" is listed twice") {
+		t.Errorf("expected a warning for the root listed twice, got %q", warn.String())
+	}
+}
@@ -0,0 +1,121 @@
+package locate
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/quux00/fslocate/boyer"
+	"github.com/quux00/fslocate/common"
+)
+
+const (
+	DB_ENV   = "FSLOCATE_DB"   // path of the db file
+	CONF_ENV = "FSLOCATE_CONF" // dir holding the config files
+
+	DB_NAME     = "fslocate.boyer"
+	INDEX_NAME  = "fslocate.indexlist"
+	IGNORE_NAME = "fslocate.ignore"
+	APP_DIR     = "fslocate" // under the XDG dirs
+)
+
+//
+// FindDbFile returns the path of the db to use, taking the first of:
+//   1. dbFile, if it isn't empty (eg from the -db flag)
+//   2. $FSLOCATE_DB
+//   3. configDb, if it isn't empty (the db in the config file)
+//   4. db/fslocate.boyer, if it exists in the current dir or confDir
+//      (the dir found by FindConfDir) is the old conf dir, as that is
+//      where fslocate used to require its db and config to be. Just
+//      having a db dir isn't enough: plenty of projects have one.
+//   5. $XDG_DATA_HOME/fslocate/fslocate.boyer, where XDG_DATA_HOME
+//      defaults to ~/.local/share
+//
+func FindDbFile(dbFile, configDb, confDir string) string {
+	if dbFile != "" {
+		return dbFile
+	}
+	if env := os.Getenv(DB_ENV); env != "" {
+		return env
+	}
+	if configDb != "" {
+		return configDb
+	}
+	if common.FileExists(boyer.OUT_FILE) || confDir == filepath.Dir(common.IgnoreFile) {
+		return boyer.OUT_FILE
+	}
+	if dir := xdgDir("XDG_DATA_HOME", ".local/share"); dir != "" {
+		return filepath.Join(dir, APP_DIR, DB_NAME)
+	}
+	return boyer.OUT_FILE
+}
+
+//
+// FindConfDir returns the dir holding the config files (the config file,
+// or the index list and ignore file), taking the first of:
+//   1. confDir, if it isn't empty (eg from the -conf flag)
+//   2. $FSLOCATE_CONF
+//   3. conf, if there is a conf/fslocate.json or conf/fslocate.indexlist
+//      in the current dir
+//      (where fslocate used to require its config to be)
+//   4. $XDG_CONFIG_HOME/fslocate, where XDG_CONFIG_HOME defaults to
+//      ~/.config
+//
+func FindConfDir(confDir string) string {
+	if confDir != "" {
+		return confDir
+	}
+	if env := os.Getenv(CONF_ENV); env != "" {
+		return env
+	}
+	legacy := filepath.Dir(common.IgnoreFile)
+	if common.FileExists(boyer.INDEX_FILE) || common.FileExists(filepath.Join(legacy, common.ConfigName)) {
+		return legacy
+	}
+	if dir := xdgDir("XDG_CONFIG_HOME", ".config"); dir != "" {
+		return filepath.Join(dir, APP_DIR)
+	}
+	return legacy
+}
+
+//
+// WithPaths returns opts with the config file, index list and ignore
+// file set to the ones in the config dir found by FindConfDir, and the
+// db set to the one found by FindDbFile. If there is a config file, the
+// db and NumIndexers (if not already set) are taken from it.
+//
+func (opts Options) WithPaths(dbFile, confDir string) (Options, error) {
+	confDir = FindConfDir(confDir)
+	opts.ConfigFile = filepath.Join(confDir, common.ConfigName)
+	opts.IndexFile = filepath.Join(confDir, INDEX_NAME)
+	opts.IgnoreFile = filepath.Join(confDir, IGNORE_NAME)
+
+	var configDb string
+	if common.FileExists(opts.ConfigFile) {
+		cfg, err := common.ReadConfig(opts.ConfigFile)
+		if err != nil {
+			return opts, err
+		}
+		configDb = cfg.Db
+		if opts.NumIndexers == 0 {
+			opts.NumIndexers = cfg.Workers
+		}
+	}
+	opts.DbFile = FindDbFile(dbFile, configDb, confDir)
+	return opts, nil
+}
+
+//
+// xdgDir returns the value of the XDG base dir env var, or the
+// default under the home dir if it is unset or not absolute (as the
+// spec says). Returns "" if there is no home dir either.
+//
+func xdgDir(env, homeDefault string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, filepath.FromSlash(homeDefault))
+}
@@ -0,0 +1,86 @@
+package locate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func chdir(t *testing.T, dir string) {
+	prev, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(prev) })
+}
+
+func withPaths(t *testing.T, dbFile, confDir string) Options {
+	opts, err := Options{}.WithPaths(dbFile, confDir)
+	if err != nil {
+		t.Fatalf("WithPaths: %v", err)
+	}
+	return opts
+}
+
+func TestFindPaths(t *testing.T) {
+	home := t.TempDir()
+	chdir(t, t.TempDir())
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv(DB_ENV, "")
+	t.Setenv(CONF_ENV, "")
+
+	// XDG defaults
+	opts := withPaths(t, "", "")
+	if exp := filepath.Join(home, ".local", "share", "fslocate", "fslocate.boyer"); opts.DbFile != exp {
+		t.Errorf("DbFile: expected %s, got %s", exp, opts.DbFile)
+	}
+	if exp := filepath.Join(home, ".config", "fslocate", "fslocate.indexlist"); opts.IndexFile != exp {
+		t.Errorf("IndexFile: expected %s, got %s", exp, opts.IndexFile)
+	}
+	t.Setenv("XDG_CONFIG_HOME", "/xdg/conf")
+	if exp := filepath.FromSlash("/xdg/conf/fslocate"); FindConfDir("") != exp {
+		t.Errorf("FindConfDir: expected %s, got %s", exp, FindConfDir(""))
+	}
+	t.Setenv("XDG_CONFIG_HOME", "relative/is/ignored")
+	if exp := filepath.Join(home, ".config", "fslocate"); FindConfDir("") != exp {
+		t.Errorf("FindConfDir: expected %s, got %s", exp, FindConfDir(""))
+	}
+
+	// a db dir of some other project isn't the old ./db dir
+	os.MkdirAll("db", 0755)
+	xdgDb := filepath.Join(home, ".local", "share", "fslocate", "fslocate.boyer")
+	if opts = withPaths(t, "", ""); opts.DbFile != xdgDb {
+		t.Errorf("DbFile: expected %s, got %s", xdgDb, opts.DbFile)
+	}
+
+	// the old ./db/fslocate.boyer and ./conf dir come before XDG
+	ioutil.WriteFile(filepath.Join("db", DB_NAME), nil, 0644)
+	if opts = withPaths(t, "", ""); opts.DbFile != filepath.Join("db", DB_NAME) {
+		t.Errorf("expected ./db file, got %+v", opts)
+	}
+	os.Remove(filepath.Join("db", DB_NAME))
+	os.MkdirAll("conf", 0755)
+	ioutil.WriteFile(filepath.Join("conf", INDEX_NAME), []byte("/\n"), 0644)
+	opts = withPaths(t, "", "")
+	if opts.DbFile != filepath.Join("db", DB_NAME) || opts.IgnoreFile != filepath.Join("conf", IGNORE_NAME) {
+		t.Errorf("expected ./db and ./conf files, got %+v", opts)
+	}
+
+	// then the env vars, then the flags
+	t.Setenv(DB_ENV, "/env/my.db")
+	t.Setenv(CONF_ENV, "/env/conf")
+	opts = withPaths(t, "", "")
+	if opts.DbFile != "/env/my.db" || opts.IndexFile != filepath.Join("/env/conf", INDEX_NAME) {
+		t.Errorf("expected env paths, got %+v", opts)
+	}
+	opts = withPaths(t, "/flag/my.db", "/flag/conf")
+	if opts.DbFile != "/flag/my.db" || opts.IgnoreFile != filepath.Join("/flag/conf", IGNORE_NAME) {
+		t.Errorf("expected flag paths, got %+v", opts)
+	}
+}